@@ -0,0 +1,91 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ranges H picks from for minute, hour, day of month, month and day of week.
+// Days of month stop at 28, so a hashed day exists in every month
+var hashRanges = [5][2]int{{0, 59}, {0, 23}, {1, 28}, {1, 12}, {0, 6}}
+
+// H, H(low-high), H/step and H(low-high)/step
+var hashPattern = regexp.MustCompile(`^H(?:\((\d+)-(\d+)\))?(?:/(\d+))?$`)
+
+// scheduleHash derives a stable number from the UID of the Dbackup
+func scheduleHash(dbackup *Dbackup, salt string) int {
+	h := fnv.New32a()
+	h.Write([]byte(string(dbackup.UID) + "/" + salt))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// ResolveSchedule replaces the H fields of the schedule with values hashed from the UID
+// and returns the offset jitterSeconds adds to every run
+func (r *Dbackup) ResolveSchedule() (string, time.Duration, error) {
+	var offset time.Duration
+	if jitter := r.Spec.JitterSeconds; jitter != nil && *jitter > 0 {
+		offset = time.Duration(scheduleHash(r, "jitter")%int(*jitter)) * time.Second
+	}
+
+	fields := strings.Fields(r.Spec.Schedule)
+	if len(fields) != len(hashRanges) {
+		return r.Spec.Schedule, offset, nil
+	}
+
+	for i, field := range fields {
+		parts := strings.Split(field, ",")
+		for j, part := range parts {
+			match := hashPattern.FindStringSubmatch(part)
+			if match == nil {
+				continue
+			}
+
+			low, high := hashRanges[i][0], hashRanges[i][1]
+			if match[1] != "" {
+				low, _ = strconv.Atoi(match[1])
+				high, _ = strconv.Atoi(match[2])
+				if low > high || low < hashRanges[i][0] || high > hashRanges[i][1] {
+					return "", 0, fmt.Errorf("Unparseable schedule %q: range of %q out of bounds", r.Spec.Schedule, part)
+				}
+			}
+
+			hash := scheduleHash(r, strconv.Itoa(i))
+			if match[3] == "" {
+				parts[j] = strconv.Itoa(low + hash%(high-low+1))
+				continue
+			}
+
+			step, _ := strconv.Atoi(match[3])
+			if step == 0 {
+				return "", 0, fmt.Errorf("Unparseable schedule %q: step of %q is zero", r.Spec.Schedule, part)
+			}
+			if step > high-low+1 {
+				step = high - low + 1
+			}
+			parts[j] = fmt.Sprintf("%d-%d/%s", low+hash%step, high, match[3])
+		}
+		fields[i] = strings.Join(parts, ",")
+	}
+
+	return strings.Join(fields, " "), offset, nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cron "github.com/robfig/cron"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func scheduledDbackup(uid, schedule string) *Dbackup {
+	return &Dbackup{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)},
+		Spec:       DbackupSpec{Schedule: schedule},
+	}
+}
+
+func TestResolveSchedule(t *testing.T) {
+	// within checks a resolved field is a number between low and high
+	within := func(low, high int) func(string) error {
+		return func(field string) error {
+			var value int
+			if _, err := fmt.Sscanf(field, "%d", &value); err != nil || fmt.Sprint(value) != field {
+				return fmt.Errorf("%q is not a number", field)
+			}
+			if value < low || value > high {
+				return fmt.Errorf("%d is not between %d and %d", value, low, high)
+			}
+			return nil
+		}
+	}
+	// stepped checks a resolved field is start-high/step with a start below low+step
+	stepped := func(low, high, step int) func(string) error {
+		return func(field string) error {
+			var start, end, by int
+			if _, err := fmt.Sscanf(field, "%d-%d/%d", &start, &end, &by); err != nil {
+				return fmt.Errorf("%q is not a stepped range", field)
+			}
+			if start < low || start >= low+step || end != high || by != step {
+				return fmt.Errorf("%q does not start below %d and step by %d up to %d", field, low+step, step, high)
+			}
+			return nil
+		}
+	}
+	same := func(want string) func(string) error {
+		return func(field string) error {
+			if field != want {
+				return fmt.Errorf("%q changed to %q", want, field)
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		schedule string
+		fields   []func(string) error
+	}{
+		{"H H * * *", []func(string) error{within(0, 59), within(0, 23), same("*"), same("*"), same("*")}},
+		{"H H H H H", []func(string) error{within(0, 59), within(0, 23), within(1, 28), within(1, 12), within(0, 6)}},
+		{"H(10-20) H(1-3) * * 1-5", []func(string) error{within(10, 20), within(1, 3), same("*"), same("*"), same("1-5")}},
+		{"H(7-7) * * * *", []func(string) error{within(7, 7), same("*"), same("*"), same("*"), same("*")}},
+		{"H/15 * * * *", []func(string) error{stepped(0, 59, 15), same("*"), same("*"), same("*"), same("*")}},
+		{"H(0-29)/10 H(8-18)/2 * * *", []func(string) error{stepped(0, 29, 10), stepped(8, 18, 2), same("*"), same("*"), same("*")}},
+		{"*/5 0 1 * *", []func(string) error{same("*/5"), same("0"), same("1"), same("*"), same("*")}},
+	}
+
+	for _, test := range tests {
+		for _, uid := range []string{"a", "b", "5f1c2d9e-0000-4000-8000-000000000000"} {
+			resolved, _, err := scheduledDbackup(uid, test.schedule).ResolveSchedule()
+			if err != nil {
+				t.Errorf("%q for %s: %v", test.schedule, uid, err)
+				continue
+			}
+			if _, err := cron.ParseStandard(resolved); err != nil {
+				t.Errorf("%q for %s resolves to %q: %v", test.schedule, uid, resolved, err)
+			}
+
+			fields := strings.Fields(resolved)
+			if len(fields) != len(test.fields) {
+				t.Errorf("%q for %s resolves to %q", test.schedule, uid, resolved)
+				continue
+			}
+			for i, check := range test.fields {
+				if err := check(fields[i]); err != nil {
+					t.Errorf("%q for %s, field %d: %v", test.schedule, uid, i, err)
+				}
+			}
+
+			again, _, _ := scheduledDbackup(uid, test.schedule).ResolveSchedule()
+			if again != resolved {
+				t.Errorf("%q for %s resolves to %q and %q", test.schedule, uid, resolved, again)
+			}
+		}
+	}
+}
+
+func TestResolveScheduleSpreadsDbackups(t *testing.T) {
+	minutes := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		resolved, _, err := scheduledDbackup(fmt.Sprintf("uid-%d", i), "H * * * *").ResolveSchedule()
+		if err != nil {
+			t.Fatal(err)
+		}
+		minutes[resolved] = true
+	}
+	if len(minutes) < 2 {
+		t.Errorf("20 Dbackups resolve to %d schedules", len(minutes))
+	}
+}
+
+func TestResolveScheduleLargeStep(t *testing.T) {
+	resolved, _, err := scheduledDbackup("a", "H/90 * * * *").ResolveSchedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var start int
+	if _, err := fmt.Sscanf(resolved, "%d-59/90", &start); err != nil || start < 0 || start > 59 {
+		t.Errorf("H/90 resolves to %q", resolved)
+	}
+}
+
+func TestResolveScheduleErrors(t *testing.T) {
+	for _, schedule := range []string{
+		"H(50-70) * * * *",
+		"H(20-10) * * * *",
+		"* H(0-24) * * *",
+		"* * H(1-31) * *",
+		"* * H(0-5) * *",
+		"* * * H(0-12) *",
+		"* * * * H(1-7)",
+		"H/0 * * * *",
+		"H(0-30)/0 * * * *",
+	} {
+		if resolved, _, err := scheduledDbackup("a", schedule).ResolveSchedule(); err == nil {
+			t.Errorf("%q resolves to %q", schedule, resolved)
+		}
+	}
+}
+
+func TestResolveScheduleJitter(t *testing.T) {
+	if _, offset, _ := scheduledDbackup("a", "* * * * *").ResolveSchedule(); offset != 0 {
+		t.Errorf("offset without jitter is %s", offset)
+	}
+
+	for _, jitter := range []int32{0, 1, 30, 3600} {
+		dbackup := scheduledDbackup("a", "* * * * *")
+		dbackup.Spec.JitterSeconds = &jitter
+		_, offset, err := dbackup.ResolveSchedule()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset < 0 || (jitter == 0 && offset != 0) || (jitter > 0 && offset >= time.Duration(jitter)*time.Second) {
+			t.Errorf("offset of jitter %d is %s", jitter, offset)
+		}
+	}
+}
@@ -23,20 +23,108 @@ import (
 
 // DbackupSpec defines the desired state of Dbackup
 type DbackupSpec struct {
-	// Cron syntax
+	// Cron syntax.
+	// A field H picks a fixed value hashed from the UID of the Dbackup,
+	// H(low-high) picks it from a range and H/step a fixed start of the steps
 	//+kubebuilder:validation:MinLength=0
 	Schedule string `json:"schedule"`
 
+	// Every run starts a fixed number of seconds below jitterSeconds
+	// after its scheduled time. The number is hashed from the UID of the Dbackup
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	JitterSeconds *int32 `json:"jitterSeconds,omitempty"`
+
+	// IANA name of the time zone the schedule is evaluated in, e.g. Europe/Berlin.
+	// Defaults to the time zone of the controller
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_+\-]*(/[A-Za-z0-9_+\-]+)*$`
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
 	// Policy of many jobs runnign at the same time
 	// +optional
 	ConcurrencyPolicy Policy `json:"concurrencyPolicy,omitempty"`
 
+	// Deadline in seconds for starting a missed run.
+	// Missed runs older than the deadline are skipped
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+
+	// Stops scheduling new jobs, running jobs are not touched.
+	// After a resume only the most recent missed run is started
+	// +optional
+	Suspend *bool `json:"suspend,omitempty"`
+
+	// Number of successful finished jobs to keep
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=3
+	// +optional
+	SuccessfulJobsHistoryLimit *int32 `json:"successfulJobsHistoryLimit,omitempty"`
+
+	// Number of failed finished jobs to keep
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	FailedJobsHistoryLimit *int32 `json:"failedJobsHistoryLimit,omitempty"`
+
+	// Pod retries of a job, whatever the failure.
+	// With a retry policy it defaults to 0, so a run is only retried when
+	// its runner reports a retryable failure and failed authentication is not tried again.
+	// Keep it at 0 then, pod retries do not look at the exit code.
+	// Without a retry policy it defaults to the backoff limit of Kubernetes jobs
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// Seconds a job may run before it is terminated and marked failed
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// Seconds after which a finished job is deleted, regardless of the history limits.
+	// A job deleted before the operator saw it finish is neither counted nor
+	// reported in lastBackup. With a retry policy it has to cover the longest backoff,
+	// a failed job deleted before its retry is due is not retried
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// Retries of runs that failed for a retryable reason,
+	// without it failed runs are not retried
+	// +optional
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
 	// Database specifications
 	Database Database `json:"database"`
 
 	// Cloud specifications
 	Cloud Cloud `json:"cloud"`
 
+	// Runner image of this Dbackup, overrides the image the operator
+	// configures for the database type and cloud provider
+	// +optional
+	RunnerImage string `json:"runnerImage,omitempty"`
+
+	// Overrides of the pod template of the backup jobs
+	// +optional
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+
+	// Compression of the dumps while they are streamed to the bucket,
+	// without it the dumps are uploaded as plain SQL
+	// +optional
+	Compression *Compression `json:"compression,omitempty"`
+
+	// Encryption of the dumps before they leave the runner,
+	// without it the dumps are only protected by the bucket
+	// +optional
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Retention of the dumps in the bucket,
+	// without it no dump is ever deleted
+	// +optional
+	Retention *Retention `json:"retention,omitempty"`
+
 	// +optional
 	Env []corev1.EnvVar `json:"env"`
 }
@@ -45,6 +133,75 @@ type Database struct {
 	// +kubebuilder:validation:Enum=postgres;mysql
 	//+kubebuilder:validation:MinLength=0
 	Type string `json:"type"`
+
+	// Secret holding the connection of the database
+	// with the keys host, port (optional), database, username and password
+	// +optional
+	ConnectionSecretRef *corev1.LocalObjectReference `json:"connectionSecretRef,omitempty"`
+
+	// Schemas to dump, all of them without it. Entries are pg_dump patterns.
+	// Only used by postgres, the schemas of mysql are its databases.
+	// Dbackups of parts of the same database need a cloud prefix each,
+	// retention and restores pick dumps by prefix and database
+	// +optional
+	IncludeSchemas []string `json:"includeSchemas,omitempty"`
+
+	// Schemas left out of the dump, pg_dump patterns. Only used by postgres
+	// +optional
+	ExcludeSchemas []string `json:"excludeSchemas,omitempty"`
+
+	// Tables to dump, all of them without it.
+	// Entries are pg_dump patterns for postgres and table names for mysql
+	// +optional
+	IncludeTables []string `json:"includeTables,omitempty"`
+
+	// Tables left out of the dump.
+	// Entries are pg_dump patterns for postgres and table names for mysql
+	// +optional
+	ExcludeTables []string `json:"excludeTables,omitempty"`
+
+	// Tables whose definition is dumped without their rows, like audit or log tables.
+	// Entries are pg_dump patterns for postgres and table names for mysql
+	// +optional
+	ExcludeTableData []string `json:"excludeTableData,omitempty"`
+
+	// Options of pg_dump and pg_restore, only used by postgres
+	// +optional
+	Postgres *PostgresOptions `json:"postgres,omitempty"`
+
+	// Dumps every database of the server instead of the one of the connection Secret,
+	// which is then only used to connect. The dumps of a run and the globals of
+	// the server are stored under <prefix>/all-<unix time>/ with one run manifest
+	// +optional
+	AllDatabases *AllDatabases `json:"allDatabases,omitempty"`
+}
+
+// AllDatabases selects the databases of a server dumped in one run.
+// Template and system databases are never dumped
+type AllDatabases struct {
+	// Databases to dump, all of them without it. Entries are shell patterns like app_*
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Databases left out of the run, shell patterns
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// PostgresOptions select the format of the dumps and how parallel they are taken and restored
+type PostgresOptions struct {
+	// Format of the dumps. plain dumps are restored with psql, the others with pg_restore.
+	// directory dumps are written to the disk of the pod before they are uploaded
+	// +kubebuilder:validation:Enum=plain;custom;directory;tar
+	// +kubebuilder:default=plain
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Parallel jobs of pg_dump for the directory format,
+	// and of pg_restore for the custom and directory formats
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Jobs int32 `json:"jobs,omitempty"`
 }
 
 type Cloud struct {
@@ -53,6 +210,136 @@ type Cloud struct {
 
 	//+kubebuilder:validation:MinLength=0
 	Bucket string `json:"bucket"`
+
+	// Region of the bucket, only used by aws
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Prefix of the object keys inside the bucket
+	// +kubebuilder:validation:Pattern=`^[^/].*$`
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Endpoint of the object store, used for S3 compatible stores
+	// and local emulators like MinIO, Azurite or fake-gcs-server
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Secret holding the credentials of the object store.
+	// aws: accessKeyId and secretAccessKey,
+	// azure: accountName and accountKey,
+	// gcp: serviceAccount.json
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// RetryPolicy retries a run outside of the cron cadence when its runner reports
+// a retryable failure, like an unreachable database or a lock timeout.
+// Failed authentication and broken configuration are never retried
+type RetryPolicy struct {
+	// Retries of one run
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=2
+	// +optional
+	Limit int32 `json:"limit,omitempty"`
+
+	// Seconds between the failure and the first retry, doubled for every further retry
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=300
+	// +optional
+	BackoffSeconds int32 `json:"backoffSeconds,omitempty"`
+}
+
+// PodTemplate is strategically merged onto the pod template of the generated jobs
+type PodTemplate struct {
+	// Labels added to the pods
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations added to the pods
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Compute resources of the runner container
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Service account of the pods, e.g. one bound to a cloud IAM role
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Secrets to pull the runner image from a private registry
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Security context of the pods
+	// +optional
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+
+	// Security context of the runner container
+	// +optional
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+}
+
+// Compression of the dumps. The algorithm picks the extension and Content-Encoding
+// of the dumps, restores detect it from the dump itself
+type Compression struct {
+	// +kubebuilder:validation:Enum=none;gzip;zstd;lz4
+	Algorithm string `json:"algorithm"`
+
+	// Level of the algorithm, its default level without it.
+	// gzip and lz4 take 1 to 9, zstd 1 to 22
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=22
+	// +optional
+	Level *int32 `json:"level,omitempty"`
+}
+
+// Encryption seals every dump with AES-256-GCM under a data key of its own.
+// The data key is stored with the dump, wrapped by the key of the Secret
+type Encryption struct {
+	// Secret holding the key encryption key under key, 32 base64 encoded bytes,
+	// and optionally its id under keyId. The id is stored with every dump,
+	// without it the key is named by its fingerprint
+	KeySecretRef corev1.LocalObjectReference `json:"keySecretRef"`
+}
+
+// Retention rules add up, a dump is kept as long as one rule keeps it.
+// The grandfather-father-son rules keep the newest dump of each period
+type Retention struct {
+	// Number of most recent dumps to keep
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// Number of most recent days to keep one dump of
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepDaily int32 `json:"keepDaily,omitempty"`
+
+	// Number of most recent weeks to keep one dump of
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepWeekly int32 `json:"keepWeekly,omitempty"`
+
+	// Number of most recent months to keep one dump of
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepMonthly int32 `json:"keepMonthly,omitempty"`
+
+	// Number of most recent years to keep one dump of
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepYearly int32 `json:"keepYearly,omitempty"`
 }
 
 // +kubebuilder:validation:Enum=Allow;Forbid;Replace
@@ -69,14 +356,104 @@ const (
 	Replace Policy = "Replace"
 )
 
+const (
+	// Backups are scheduled, credentials are in place and the last backup succeeded
+	ConditionReady = "Ready"
+
+	// Every referenced Secret exists and holds the keys the runner needs
+	ConditionCredentialsReady = "CredentialsReady"
+
+	// The schedule is valid and the next run is planned
+	ConditionScheduled = "Scheduled"
+
+	// The most recent backups failed
+	ConditionBackupFailing = "BackupFailing"
+)
+
+// BackupResult is what the runner reports in the termination message of a finished backup
+type BackupResult struct {
+	// Job that took the backup
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// Key of the dump object in the bucket
+	Key string `json:"key"`
+
+	// URI of the dump object
+	Location string `json:"location"`
+
+	// Size of the dump object in bytes
+	Size int64 `json:"size"`
+
+	// Checksum of the dump object in the form <algorithm>:<hex>
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// Time the runner took for dump and upload
+	// +optional
+	Duration metav1.Duration `json:"duration,omitempty"`
+
+	// Version reported by the database server
+	// +optional
+	DatabaseVersion string `json:"databaseVersion,omitempty"`
+}
+
 // DbackupStatus defines the observed state of Dbackup
 type DbackupStatus struct {
 	// +optional
 	Active []corev1.ObjectReference `json:"active,omitempty"`
+
+	// Schedule with the H fields replaced by their hashed values
+	// +optional
+	ResolvedSchedule string `json:"resolvedSchedule,omitempty"`
+
+	// Seconds every run starts after its scheduled time, picked from jitterSeconds
+	// +optional
+	JitterOffsetSeconds int32 `json:"jitterOffsetSeconds,omitempty"`
+
+	// Last time a backup job was scheduled
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// Last time a backup job completed successfully
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// Result of the last successful backup as reported by the runner
+	// +optional
+	LastBackup *BackupResult `json:"lastBackup,omitempty"`
+
+	// Number of failed backups since the last successful one
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// Scheduled time of the newest finished job counted into consecutiveFailures
+	// +optional
+	LastFinishedScheduleTime *metav1.Time `json:"lastFinishedScheduleTime,omitempty"`
+
+	// Scheduled times of runs before lastFinishedScheduleTime that were still running
+	// when later runs were counted. They are counted once they finish
+	// +optional
+	UncountedScheduleTimes []metav1.Time `json:"uncountedScheduleTimes,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+//+kubebuilder:printcolumn:name="Resolved",type=string,JSONPath=`.status.resolvedSchedule`,priority=1
+//+kubebuilder:printcolumn:name="Time Zone",type=string,JSONPath=`.spec.timeZone`,priority=1
+//+kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Last Schedule",type=date,JSONPath=`.status.lastScheduleTime`
+//+kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulTime`
+//+kubebuilder:printcolumn:name="Failures",type=integer,JSONPath=`.status.consecutiveFailures`
+//+kubebuilder:printcolumn:name="Location",type=string,JSONPath=`.status.lastBackup.location`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Dbackup is the Schema for the dbackups API
 type Dbackup struct {
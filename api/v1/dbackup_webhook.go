@@ -0,0 +1,279 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cron "github.com/robfig/cron"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var dbackuplog = logf.Log.WithName("dbackup-resource")
+
+func (r *Dbackup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-batch-k8s-htw-berlin-de-v1-dbackup,mutating=true,failurePolicy=fail,sideEffects=None,groups=batch.k8s.htw-berlin.de,resources=dbackups,verbs=create;update,versions=v1,name=mdbackup.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Dbackup{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Dbackup) Default() {
+	dbackuplog.Info("default", "name", r.Name)
+
+	if r.Spec.ConcurrencyPolicy == "" {
+		r.Spec.ConcurrencyPolicy = Allow
+	}
+
+	if r.Spec.Suspend == nil {
+		r.Spec.Suspend = new(bool)
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-batch-k8s-htw-berlin-de-v1-dbackup,mutating=false,failurePolicy=fail,sideEffects=None,groups=batch.k8s.htw-berlin.de,resources=dbackups,verbs=create;update,versions=v1,name=vdbackup.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Dbackup{}
+
+// Runners lists the cloud providers a runner exists for, by database type
+var Runners = map[string][]string{
+	"postgres": {"aws", "azure", "gcp"},
+	"mysql":    {"aws", "azure", "gcp"},
+}
+
+// CompressionLevels is the highest level of each compression algorithm
+var CompressionLevels = map[string]int32{
+	"gzip": 9,
+	"zstd": 22,
+	"lz4":  9,
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Dbackup) ValidateCreate() error {
+	dbackuplog.Info("validate create", "name", r.Name)
+
+	return r.validateDbackup(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Dbackup) ValidateUpdate(old runtime.Object) error {
+	dbackuplog.Info("validate update", "name", r.Name)
+
+	return r.validateDbackup(old.(*Dbackup))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Dbackup) ValidateDelete() error {
+	dbackuplog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+func (r *Dbackup) validateDbackup(old *Dbackup) error {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+
+	allErrs = append(allErrs, r.validateSchedule(spec)...)
+	allErrs = append(allErrs, r.validateRunner(spec)...)
+	allErrs = append(allErrs, r.validateCompression(spec)...)
+	allErrs = append(allErrs, r.validateRetry(spec)...)
+	allErrs = append(allErrs, validateDatabaseOptions(r.Spec.Database, spec.Child("database"))...)
+	allErrs = append(allErrs, validateSecretRef(r.Spec.Database.ConnectionSecretRef, spec.Child("database", "connectionSecretRef"))...)
+	allErrs = append(allErrs, validateSecretRef(r.Spec.Cloud.CredentialsSecretRef, spec.Child("cloud", "credentialsSecretRef"))...)
+	if r.Spec.Encryption != nil {
+		allErrs = append(allErrs, validateSecretRef(&r.Spec.Encryption.KeySecretRef, spec.Child("encryption", "keySecretRef"))...)
+	}
+	if old != nil {
+		allErrs = append(allErrs, r.validateTargetChange(old, spec)...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "Dbackup"},
+		r.Name, allErrs)
+}
+
+func (r *Dbackup) validateSchedule(spec *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	resolved, _, err := r.ResolveSchedule()
+	if err == nil {
+		_, err = cron.ParseStandard(resolved)
+	}
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(spec.Child("schedule"), r.Spec.Schedule, err.Error()))
+	}
+
+	if r.Spec.TimeZone != "" {
+		if _, err := time.LoadLocation(r.Spec.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(spec.Child("timeZone"), r.Spec.TimeZone, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *Dbackup) validateRunner(spec *field.Path) field.ErrorList {
+	for _, provider := range Runners[r.Spec.Database.Type] {
+		if provider == r.Spec.Cloud.Provider {
+			return nil
+		}
+	}
+
+	return field.ErrorList{field.Invalid(spec.Child("cloud", "provider"), r.Spec.Cloud.Provider,
+		fmt.Sprintf("no runner backs up %s to %s", r.Spec.Database.Type, r.Spec.Cloud.Provider))}
+}
+
+func (r *Dbackup) validateCompression(spec *field.Path) field.ErrorList {
+	compression := r.Spec.Compression
+	if compression == nil || compression.Level == nil {
+		return nil
+	}
+
+	max, ok := CompressionLevels[compression.Algorithm]
+	if !ok {
+		return field.ErrorList{field.Forbidden(spec.Child("compression", "level"),
+			fmt.Sprintf("%s has no level", compression.Algorithm))}
+	}
+	if *compression.Level > max {
+		return field.ErrorList{field.Invalid(spec.Child("compression", "level"), *compression.Level,
+			fmt.Sprintf("%s takes levels 1 to %d", compression.Algorithm, max))}
+	}
+	return nil
+}
+
+// validateRetry keeps failed jobs until their last retry is due,
+// the retry of a run is created from its failed job
+func (r *Dbackup) validateRetry(spec *field.Path) field.ErrorList {
+	retry, ttl := r.Spec.Retry, r.Spec.TTLSecondsAfterFinished
+	if retry == nil || ttl == nil || retry.Limit == 0 {
+		return nil
+	}
+
+	longest := int64(retry.BackoffSeconds)
+	for i := int32(1); i < retry.Limit && longest <= int64(*ttl); i++ {
+		longest *= 2
+	}
+	if int64(*ttl) < longest {
+		return field.ErrorList{field.Invalid(spec.Child("ttlSecondsAfterFinished"), *ttl,
+			fmt.Sprintf("deletes failed jobs before their retry, the backoff of retry %d is %d seconds", retry.Limit, longest))}
+	}
+	return nil
+}
+
+// validateDatabaseOptions also rejects commas in the dump filters,
+// they are handed to the runner as comma separated lists
+func validateDatabaseOptions(database Database, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	postgresOnly := func(name string, set bool) {
+		if set && database.Type != "postgres" {
+			allErrs = append(allErrs, field.Forbidden(path.Child(name),
+				fmt.Sprintf("only used by postgres, not by %s", database.Type)))
+		}
+	}
+	postgresOnly("postgres", database.Postgres != nil)
+	postgresOnly("includeSchemas", len(database.IncludeSchemas) > 0)
+	postgresOnly("excludeSchemas", len(database.ExcludeSchemas) > 0)
+
+	filters := []struct {
+		name    string
+		entries []string
+	}{
+		{"includeSchemas", database.IncludeSchemas},
+		{"excludeSchemas", database.ExcludeSchemas},
+		{"includeTables", database.IncludeTables},
+		{"excludeTables", database.ExcludeTables},
+		{"excludeTableData", database.ExcludeTableData},
+	}
+	for _, filter := range filters {
+		for i, entry := range filter.entries {
+			if entry == "" || strings.Contains(entry, ",") {
+				allErrs = append(allErrs, field.Invalid(path.Child(filter.name).Index(i), entry,
+					"has to be a non empty name without commas"))
+			}
+		}
+	}
+
+	if all := database.AllDatabases; all != nil {
+		patterns := []struct {
+			name    string
+			entries []string
+		}{
+			{"include", all.Include},
+			{"exclude", all.Exclude},
+		}
+		for _, pattern := range patterns {
+			for i, entry := range pattern.entries {
+				if _, err := filepath.Match(entry, ""); entry == "" || strings.Contains(entry, ",") || err != nil {
+					allErrs = append(allErrs, field.Invalid(path.Child("allDatabases", pattern.name).Index(i), entry,
+						"has to be a non empty shell pattern without commas"))
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
+func validateSecretRef(ref *corev1.LocalObjectReference, path *field.Path) field.ErrorList {
+	if ref != nil && ref.Name == "" {
+		return field.ErrorList{field.Required(path.Child("name"), "the referenced Secret has to be named")}
+	}
+	return nil
+}
+
+// validateTargetChange blocks moving the backups to another database or bucket
+// while runs are active. The running jobs would still write to the old one
+func (r *Dbackup) validateTargetChange(old *Dbackup, spec *field.Path) field.ErrorList {
+	if len(old.Status.Active) == 0 {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	forbid := func(path *field.Path, oldValue, newValue string) {
+		if oldValue != newValue {
+			allErrs = append(allErrs, field.Forbidden(path,
+				fmt.Sprintf("cannot change while %d runs are active", len(old.Status.Active))))
+		}
+	}
+
+	forbid(spec.Child("database", "type"), old.Spec.Database.Type, r.Spec.Database.Type)
+	forbid(spec.Child("cloud", "provider"), old.Spec.Cloud.Provider, r.Spec.Cloud.Provider)
+	forbid(spec.Child("cloud", "bucket"), old.Spec.Cloud.Bucket, r.Spec.Cloud.Bucket)
+	forbid(spec.Child("cloud", "prefix"), old.Spec.Cloud.Prefix, r.Spec.Cloud.Prefix)
+	forbid(spec.Child("cloud", "endpoint"), old.Spec.Cloud.Endpoint, r.Spec.Cloud.Endpoint)
+
+	return allErrs
+}
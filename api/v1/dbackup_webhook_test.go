@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validDbackup() *Dbackup {
+	return &Dbackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", UID: "a"},
+		Spec: DbackupSpec{
+			Schedule: "H 2 * * *",
+			TimeZone: "Europe/Berlin",
+			Database: Database{
+				Type:                "postgres",
+				ConnectionSecretRef: &corev1.LocalObjectReference{Name: "postgres"},
+			},
+			Cloud: Cloud{Provider: "aws", Bucket: "backups", Prefix: "app"},
+		},
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// invalidFields returns the fields validateDbackup rejects, sorted
+func invalidFields(t *testing.T, dbackup, old *Dbackup) []string {
+	t.Helper()
+	err := dbackup.validateDbackup(old)
+	if err == nil {
+		return nil
+	}
+
+	status, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("validation fails with %v, not an Invalid status", err)
+	}
+	var fields []string
+	for _, cause := range status.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestValidateDbackup(t *testing.T) {
+	level := int32(30)
+
+	tests := []struct {
+		name   string
+		change func(*Dbackup)
+		fields []string
+	}{
+		{"valid", func(*Dbackup) {}, nil},
+		{"unparseable schedule", func(d *Dbackup) { d.Spec.Schedule = "every day" }, []string{"spec.schedule"}},
+		{"hashed range out of bounds", func(d *Dbackup) { d.Spec.Schedule = "H(0-90) * * * *" }, []string{"spec.schedule"}},
+		{"zero hashed step", func(d *Dbackup) { d.Spec.Schedule = "H/0 * * * *" }, []string{"spec.schedule"}},
+		{"unknown time zone", func(d *Dbackup) { d.Spec.TimeZone = "Europe/Nowhere" }, []string{"spec.timeZone"}},
+		{"no runner", func(d *Dbackup) { d.Spec.Cloud.Provider = "ftp" }, []string{"spec.cloud.provider"}},
+		{"unnamed Secret", func(d *Dbackup) { d.Spec.Database.ConnectionSecretRef.Name = "" }, []string{"spec.database.connectionSecretRef.name"}},
+		{"compression level above the algorithm", func(d *Dbackup) {
+			d.Spec.Compression = &Compression{Algorithm: "gzip", Level: &level}
+		}, []string{"spec.compression.level"}},
+		{"schemas of mysql", func(d *Dbackup) {
+			d.Spec.Database.Type = "mysql"
+			d.Spec.Database.IncludeSchemas = []string{"public"}
+		}, []string{"spec.database.includeSchemas"}},
+		{"ttl within the first backoff", func(d *Dbackup) {
+			d.Spec.TTLSecondsAfterFinished = int32Ptr(60)
+			d.Spec.Retry = &RetryPolicy{Limit: 1, BackoffSeconds: 300}
+		}, []string{"spec.ttlSecondsAfterFinished"}},
+		{"ttl within the doubled backoff of the last retry", func(d *Dbackup) {
+			d.Spec.TTLSecondsAfterFinished = int32Ptr(1000)
+			d.Spec.Retry = &RetryPolicy{Limit: 3, BackoffSeconds: 300}
+		}, []string{"spec.ttlSecondsAfterFinished"}},
+		{"ttl covering every backoff", func(d *Dbackup) {
+			d.Spec.TTLSecondsAfterFinished = int32Ptr(1200)
+			d.Spec.Retry = &RetryPolicy{Limit: 3, BackoffSeconds: 300}
+		}, nil},
+		{"ttl without retries", func(d *Dbackup) {
+			d.Spec.TTLSecondsAfterFinished = int32Ptr(0)
+			d.Spec.Retry = &RetryPolicy{Limit: 0, BackoffSeconds: 300}
+		}, nil},
+		{"comma in a filter", func(d *Dbackup) { d.Spec.Database.ExcludeTables = []string{"a,b"} }, []string{"spec.database.excludeTables[0]"}},
+		{"every error at once", func(d *Dbackup) {
+			d.Spec.Schedule = "every day"
+			d.Spec.TimeZone = "Europe/Nowhere"
+		}, []string{"spec.schedule", "spec.timeZone"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbackup := validDbackup()
+			test.change(dbackup)
+			if fields := invalidFields(t, dbackup, nil); strings.Join(fields, " ") != strings.Join(test.fields, " ") {
+				t.Errorf("invalid fields %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
+
+func TestValidateTargetChange(t *testing.T) {
+	active := []corev1.ObjectReference{{Kind: "Job", Name: "backup-1622512800"}}
+
+	tests := []struct {
+		name   string
+		active []corev1.ObjectReference
+		change func(*Dbackup)
+		fields []string
+	}{
+		{"no change while active", active, func(*Dbackup) {}, nil},
+		{"bucket without active runs", nil, func(d *Dbackup) { d.Spec.Cloud.Bucket = "other" }, nil},
+		{"every target without active runs", nil, func(d *Dbackup) {
+			d.Spec.Database.Type = "mysql"
+			d.Spec.Cloud = Cloud{Provider: "gcp", Bucket: "other", Prefix: "other", Endpoint: "http://minio:9000"}
+		}, nil},
+		{"bucket while active", active, func(d *Dbackup) { d.Spec.Cloud.Bucket = "other" }, []string{"spec.cloud.bucket"}},
+		{"every target while active", active, func(d *Dbackup) {
+			d.Spec.Database.Type = "mysql"
+			d.Spec.Cloud = Cloud{Provider: "gcp", Bucket: "other", Prefix: "other", Endpoint: "http://minio:9000"}
+		}, []string{"spec.cloud.bucket", "spec.cloud.endpoint", "spec.cloud.prefix", "spec.cloud.provider", "spec.database.type"}},
+		{"schedule while active", active, func(d *Dbackup) { d.Spec.Schedule = "*/5 * * * *" }, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := validDbackup()
+			old.Status.Active = test.active
+			dbackup := validDbackup()
+			dbackup.Status.Active = test.active
+			test.change(dbackup)
+			if fields := invalidFields(t, dbackup, old); strings.Join(fields, " ") != strings.Join(test.fields, " ") {
+				t.Errorf("invalid fields %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
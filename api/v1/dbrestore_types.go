@@ -0,0 +1,134 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DbrestoreSpec defines the desired state of Dbrestore
+type DbrestoreSpec struct {
+	// Dbackup in the same namespace to restore from.
+	// Its cloud settings and env are reused for the restore job
+	// +optional
+	BackupRef *corev1.LocalObjectReference `json:"backupRef,omitempty"`
+
+	// Key of the dump object in the bucket.
+	// When empty the last backup of the referenced Dbackup is restored,
+	// without backupRef the most recent dump of the target database
+	// +optional
+	ObjectKey string `json:"objectKey,omitempty"`
+
+	// Checksum the dump has to match, in the form sha256:<hex>.
+	// Defaults to the checksum of the last backup of the referenced Dbackup
+	// when it is the one restored. The dump is verified against its manifest as well
+	// +kubebuilder:validation:Pattern=`^sha256:[0-9a-f]{64}$`
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// Target database specifications
+	Database Database `json:"database"`
+
+	// Cloud specifications, required when no backupRef is given
+	// +optional
+	Cloud *Cloud `json:"cloud,omitempty"`
+
+	// Runner image of the restore job.
+	// Defaults to the runner image of the referenced Dbackup
+	// +optional
+	RunnerImage string `json:"runnerImage,omitempty"`
+
+	// Overrides of the pod template of the restore job.
+	// Defaults to the pod template of the referenced Dbackup
+	// +optional
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+
+	// Encryption key of the dumps.
+	// Defaults to the encryption of the referenced Dbackup
+	// +optional
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Env of the restore job, appended after the env of the referenced Dbackup
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed
+type RestorePhase string
+
+const (
+	// Restore job is not created yet
+	RestorePending RestorePhase = "Pending"
+
+	// Restore job is running
+	RestoreRunning RestorePhase = "Running"
+
+	// Restore job completed
+	RestoreSucceeded RestorePhase = "Succeeded"
+
+	// Restore job failed or the restore could not be started
+	RestoreFailed RestorePhase = "Failed"
+)
+
+// DbrestoreStatus defines the observed state of Dbrestore
+type DbrestoreStatus struct {
+	// +optional
+	Phase RestorePhase `json:"phase,omitempty"`
+
+	// Human readable reason of the current phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Job running the restore
+	// +optional
+	Job *corev1.ObjectReference `json:"job,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.spec.backupRef.name`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Dbrestore is the Schema for the dbrestores API
+type Dbrestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DbrestoreSpec   `json:"spec,omitempty"`
+	Status DbrestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DbrestoreList contains a list of Dbrestore
+type DbrestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Dbrestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Dbrestore{}, &DbrestoreList{})
+}
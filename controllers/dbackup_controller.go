@@ -19,25 +19,31 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
-	cron "github.com/robfig/cron"
 	kubebatchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	reference "k8s.io/client-go/tools/reference"
 )
 
 // DbackupReconciler reconciles a Dbackup object
 type DbackupReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Images   RunnerImages
 	Time
 }
 type realTime struct{}
@@ -66,11 +72,20 @@ type Time interface {
 //+kubebuilder:rbac:groups=batch.k8s.htw-berlin.de,resources=dbackups/finalizers,verbs=update
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=jobs/status,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 var (
 	annotation = "batch.k8s.htw-berlin.de/scheduled-at"
-	imageName  = "aws-runner"
-	image      = "ahmedmahmoud25/dbackup-postgres-aws:master"
+
+	// number of the retry a job runs, the first attempt has none
+	attemptAnnotation = "batch.k8s.htw-berlin.de/attempt"
+	imageName         = "aws-runner"
+
+	// how long to wait for replaced jobs to be deleted before checking again
+	replaceRetryInterval = 5 * time.Second
+
+	// backoff limit of jobs retried by the operator
+	noBackoff = int32(0)
 )
 
 func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -95,10 +110,12 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 	/*
 		List Active jobs of type job in apiVersion batch/v1
-		This is a generic kubernetes object to execute runs
+		This is a generic kubernetes object to execute runs.
+		Only jobs owned by this Dbackup are listed, restore jobs and jobs
+		of other Dbackups in the namespace are ignored
 	*/
 	var kubeJobs kubebatchv1.JobList
-	if err := r.List(ctx, &kubeJobs, client.InNamespace(req.Namespace)); err != nil {
+	if err := r.List(ctx, &kubeJobs, client.InNamespace(req.Namespace), client.MatchingFields{owner: req.Name}); err != nil {
 		log.Error(err, "unable to list Kubernetes Jobs")
 		return ctrl.Result{}, err
 	}
@@ -174,80 +191,311 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		"failed kube jobs", len(failedKubeJobs))
 
 	/*
-		Update Dbackup Status
+		Record the last scheduled run and the last successful backup.
+		Both are kept when the jobs they come from are deleted
 	*/
-	if err := r.Status().Update(ctx, &dbackup); err != nil {
-		log.Error(err, "unable to update Dbackup status")
-		return ctrl.Result{}, err
+	if mostRecentTime != nil && (dbackup.Status.LastScheduleTime == nil || dbackup.Status.LastScheduleTime.Time.Before(*mostRecentTime)) {
+		dbackup.Status.LastScheduleTime = &metav1.Time{Time: *mostRecentTime}
+	}
+
+	var lastSuccessfulJob *kubebatchv1.Job
+	for _, job := range successfulKubeJobs {
+		completion := job.Status.CompletionTime
+		if completion == nil {
+			continue
+		}
+		if lastSuccessfulJob == nil || lastSuccessfulJob.Status.CompletionTime.Before(completion) {
+			lastSuccessfulJob = job
+		}
+		if dbackup.Status.LastSuccessfulTime == nil || dbackup.Status.LastSuccessfulTime.Before(completion) {
+			dbackup.Status.LastSuccessfulTime = completion
+		}
 	}
 
 	/*
-		Extract next schedule based on the first creation of the a job -> var earliest
-		and the givin cron specification -> cron.ParseStandard(dbackup.Spec.Schedule)
+		The runner reports key, size and checksum of the dump in its termination message
 	*/
-	getNextSchedule := func(dbackup *batchv1.Dbackup, now time.Time) (lastMissed time.Time, next time.Time, err error) {
-		sched, err := cron.ParseStandard(dbackup.Spec.Schedule)
+	if lastSuccessfulJob != nil && (dbackup.Status.LastBackup == nil || dbackup.Status.LastBackup.Job != lastSuccessfulJob.Name) {
+		result, err := jobResult(ctx, r.Client, lastSuccessfulJob)
 		if err != nil {
-			return time.Time{}, time.Time{}, fmt.Errorf("Unparseable schedule %q: %v", dbackup.Spec.Schedule, err)
+			log.Error(err, "unable to read backup result", "job", lastSuccessfulJob)
+		} else if result != nil {
+			dbackup.Status.LastBackup = result
 		}
+	}
 
-		earliest := dbackup.ObjectMeta.CreationTimestamp.Time
-
-		if earliest.After(now) {
-			return time.Time{}, sched.Next(now), nil
+	scheduledTimeOrCreation := func(job *kubebatchv1.Job) time.Time {
+		scheduledTime, err := getScheduledTimeForJob(job)
+		if err != nil || scheduledTime == nil {
+			return job.CreationTimestamp.Time
 		}
+		return *scheduledTime
+	}
 
-		for t := sched.Next(earliest); !t.After(now); t = sched.Next(t) {
-			fmt.Println("t:", t)
-			lastMissed = t
+	/*
+		A run is the job of a scheduled time and its retries,
+		the latest attempt decides the outcome of the run
+	*/
+	latestAttempts := make(map[int64]*kubebatchv1.Job)
+	for i := range kubeJobs.Items {
+		job := &kubeJobs.Items[i]
+		key := scheduledTimeOrCreation(job).Unix()
+		if latest, ok := latestAttempts[key]; !ok || jobAttempt(latest) < jobAttempt(job) {
+			latestAttempts[key] = job
 		}
-		return lastMissed, sched.Next(now), nil
 	}
 
-	missed, next, err := getNextSchedule(&dbackup, r.Now())
-	if err != nil {
-		log.Error(err, "When is next schedule?")
-		return ctrl.Result{}, nil
+	var runs []*kubebatchv1.Job
+	for _, job := range latestAttempts {
+		runs = append(runs, job)
 	}
+	sort.Slice(runs, func(i, j int) bool {
+		return scheduledTimeOrCreation(runs[i]).Before(scheduledTimeOrCreation(runs[j]))
+	})
 
 	/*
-		Requst a reconcile on schedule time
+		A failed run waits for a retry when its runner exited with a retryable error
+		and the retry policy has attempts left
 	*/
-	result := ctrl.Result{RequeueAfter: next.Sub(r.Now())}
-	log = log.WithValues("now", r.Now(), "next", next)
+	retryPending := make(map[string]bool)
+	for _, job := range runs {
+		_, finishedType := didJobFinish(job)
+		if finishedType != kubebatchv1.JobFailed || dbackup.Spec.Retry == nil || jobAttempt(job) >= int(dbackup.Spec.Retry.Limit) {
+			continue
+		}
 
-	if missed.IsZero() {
-		log.V(1).Info("sleeping until next")
-		return result, nil
+		code, ok, err := jobExitCode(ctx, r.Client, job)
+		if err != nil {
+			log.Error(err, "unable to read exit code of failed job", "job", job)
+			continue
+		}
+		retryPending[job.Name] = ok && code == retryableExitCode
 	}
 
 	/*
-		Forbid deleting the running job if the policy is forbid
+		Count the failed runs since the last successful one.
+		Finished runs are walked in schedule order, runs up to lastFinishedScheduleTime
+		were counted by an earlier reconcile and may be deleted by now.
+		A run that is still running or retried is skipped and remembered as uncounted,
+		so a hung job does not stop the count of the runs after it.
+		It is counted when it finishes, a late failure adds to the streak,
+		a late success does not end the failures of the runs after it
 	*/
-	if dbackup.Spec.ConcurrencyPolicy == batchv1.Forbid && len(activeKubeJobs) > 0 {
-		log.V(1).Info("Policy Forbids creation", "Active Jobs", len(activeKubeJobs))
-		return result, nil
+	uncounted := make(map[int64]bool)
+	for _, pending := range dbackup.Status.UncountedScheduleTimes {
+		uncounted[pending.Unix()] = true
+	}
+	dbackup.Status.UncountedScheduleTimes = nil
+
+	for _, job := range runs {
+		scheduledTime := scheduledTimeOrCreation(job)
+		counted := dbackup.Status.LastFinishedScheduleTime != nil && !scheduledTime.After(dbackup.Status.LastFinishedScheduleTime.Time)
+		if counted && !uncounted[scheduledTime.Unix()] {
+			continue
+		}
+
+		finished, finishedType := didJobFinish(job)
+		if retryPending[job.Name] || !finished {
+			dbackup.Status.UncountedScheduleTimes = append(dbackup.Status.UncountedScheduleTimes, metav1.Time{Time: scheduledTime})
+			continue
+		}
+
+		if counted {
+			if finishedType == kubebatchv1.JobFailed {
+				dbackup.Status.ConsecutiveFailures++
+			}
+			continue
+		}
+
+		if finishedType == kubebatchv1.JobFailed {
+			dbackup.Status.ConsecutiveFailures++
+		} else {
+			dbackup.Status.ConsecutiveFailures = 0
+		}
+		dbackup.Status.LastFinishedScheduleTime = &metav1.Time{Time: scheduledTime}
 	}
 
 	/*
-		if the pocliy specifices to replace running jobs in the same time
-		the running job should be deleted and replaced
+		Delete the oldest finished jobs above the history limits.
+		Jobs are ordered by their scheduled-at annotation,
+		jobs without it by their creation
 	*/
-	if dbackup.Spec.ConcurrencyPolicy == batchv1.Replace {
-		for _, activeKubeJob := range activeKubeJobs {
-			if err := r.Delete(ctx, activeKubeJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
-				log.Error(err, "unable to delete running kubernetes job", "job", activeKubeJob)
-				return ctrl.Result{}, err
+	deleteOldJobs := func(jobs []*kubebatchv1.Job, limit *int32) {
+		if limit == nil || int32(len(jobs)) <= *limit {
+			return
+		}
+
+		sort.Slice(jobs, func(i, j int) bool {
+			return scheduledTimeOrCreation(jobs[i]).Before(scheduledTimeOrCreation(jobs[j]))
+		})
+
+		for _, job := range jobs[:int32(len(jobs))-*limit] {
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "unable to delete old job", "job", job)
+			} else {
+				log.V(0).Info("deleted old job", "job", job)
 			}
 		}
 	}
 
+	var failedFinalKubeJobs []*kubebatchv1.Job
+	for _, job := range failedKubeJobs {
+		if !retryPending[job.Name] {
+			failedFinalKubeJobs = append(failedFinalKubeJobs, job)
+		}
+	}
+
+	deleteOldJobs(successfulKubeJobs, dbackup.Spec.SuccessfulJobsHistoryLimit)
+	deleteOldJobs(failedFinalKubeJobs, dbackup.Spec.FailedJobsHistoryLimit)
+
+	/*
+		Check the referenced Secrets before any job is created,
+		a job with a missing Secret never gets a running pod
+	*/
+	credentials := metav1.Condition{
+		Type:               batchv1.ConditionCredentialsReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SecretsFound",
+		ObservedGeneration: dbackup.Generation,
+	}
+	secretErr := checkSecrets(ctx, r.Client, dbackup.Namespace, dbackup.Spec.Database, dbackup.Spec.Cloud, dbackup.Spec.Encryption)
+	if secretErr != nil {
+		invalid, ok := secretErr.(*secretError)
+		if !ok {
+			log.Error(secretErr, "unable to check referenced Secrets")
+			return ctrl.Result{}, secretErr
+		}
+		credentials.Status = metav1.ConditionFalse
+		credentials.Reason = invalid.reason
+		credentials.Message = invalid.message
+	}
+	meta.SetStatusCondition(&dbackup.Status.Conditions, credentials)
+
+	/*
+		Show the schedule the H fields and the jitter resolve to
+	*/
+	if resolved, offset, err := dbackup.ResolveSchedule(); err == nil {
+		dbackup.Status.ResolvedSchedule = resolved
+		dbackup.Status.JitterOffsetSeconds = int32(offset / time.Second)
+	}
+
+	/*
+		A suspended Dbackup keeps its jobs and history but schedules nothing
+	*/
+	suspended := dbackup.Spec.Suspend != nil && *dbackup.Spec.Suspend
+
+	var missed, next time.Time
+	var scheduleErr error
+	if !suspended {
+		var tooMany bool
+		missed, next, tooMany, scheduleErr = getNextSchedule(&dbackup, r.Now())
+		if tooMany {
+			r.Recorder.Eventf(&dbackup, corev1.EventTypeWarning, "TooManyMissedRuns",
+				"more than %d runs were missed, only the most recent one is started. Set startingDeadlineSeconds to limit the catch-up", maxMissedSchedules)
+		}
+	}
+
+	scheduled := metav1.Condition{
+		Type:               batchv1.ConditionScheduled,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Scheduled",
+		Message:            fmt.Sprintf("next run at %s", next.Format(time.RFC3339)),
+		ObservedGeneration: dbackup.Generation,
+	}
+	if scheduleErr != nil {
+		scheduled.Status = metav1.ConditionFalse
+		scheduled.Reason = "InvalidSchedule"
+		scheduled.Message = scheduleErr.Error()
+	} else if suspended {
+		scheduled.Status = metav1.ConditionFalse
+		scheduled.Reason = "Suspended"
+		scheduled.Message = "scheduling is suspended"
+	}
+	meta.SetStatusCondition(&dbackup.Status.Conditions, scheduled)
+
+	failing := metav1.Condition{
+		Type:               batchv1.ConditionBackupFailing,
+		Status:             metav1.ConditionFalse,
+		Reason:             "BackupSucceeded",
+		ObservedGeneration: dbackup.Generation,
+	}
+	if dbackup.Status.ConsecutiveFailures > 0 {
+		failing.Status = metav1.ConditionTrue
+		failing.Reason = "BackupFailed"
+		failing.Message = fmt.Sprintf("%d consecutive backups failed", dbackup.Status.ConsecutiveFailures)
+	}
+	meta.SetStatusCondition(&dbackup.Status.Conditions, failing)
+
+	/*
+		Ready sums up the other conditions,
+		the first one that is not healthy gives the reason
+	*/
+	ready := metav1.Condition{
+		Type:               batchv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Ready",
+		ObservedGeneration: dbackup.Generation,
+	}
+	for _, condition := range []metav1.Condition{credentials, scheduled} {
+		if ready.Status == metav1.ConditionTrue && condition.Status != metav1.ConditionTrue {
+			ready.Status = metav1.ConditionFalse
+			ready.Reason = condition.Reason
+			ready.Message = condition.Message
+		}
+	}
+	if ready.Status == metav1.ConditionTrue && failing.Status == metav1.ConditionTrue {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = failing.Reason
+		ready.Message = failing.Message
+	}
+	meta.SetStatusCondition(&dbackup.Status.Conditions, ready)
+
+	/*
+		Update Dbackup Status
+	*/
+	if err := r.Status().Update(ctx, &dbackup); err != nil {
+		log.Error(err, "unable to update Dbackup status")
+		return ctrl.Result{}, err
+	}
+
+	if secretErr != nil {
+		log.V(1).Info("waiting for Secrets", "reason", credentials.Message)
+		return ctrl.Result{RequeueAfter: secretRetryInterval}, nil
+	}
+
+	if suspended {
+		log.V(1).Info("suspended, not scheduling")
+		return ctrl.Result{}, nil
+	}
+
 	/*
 		function stored in avariable to create a job object with the name of the Dbackup object and time unix signature
 		for unique naming for each created as a Job
 	*/
-	createBackupJob := func(backupJob *batchv1.Dbackup, creationTime time.Time) (*kubebatchv1.Job, error) {
+	createBackupJob := func(backupJob *batchv1.Dbackup, creationTime time.Time, attempt int) (*kubebatchv1.Job, error) {
 		name := fmt.Sprintf("%s-%d", backupJob.Name, creationTime.Unix())
+		if attempt > 0 {
+			name = fmt.Sprintf("%s-retry-%d", name, attempt)
+		}
+
+		env := append([]corev1.EnvVar{}, backupJob.Spec.Env...)
+		env = append(env, specEnv(backupJob.Spec.Database, backupJob.Spec.Cloud)...)
+		env = append(env, retentionEnv(backupJob.Spec.Retention)...)
+		env = append(env, compressionEnv(backupJob.Spec.Compression)...)
+		env = append(env, encryptionEnv(backupJob.Spec.Encryption)...)
+		env = append(env, corev1.EnvVar{Name: dbackupUIDEnv, Value: string(backupJob.UID)})
+
+		runnerImage := r.Images.Image(backupJob.Spec.Database.Type, backupJob.Spec.Cloud.Provider, backupJob.Spec.RunnerImage)
+
+		/*
+			With a retry policy the operator retries by the exit code of the runner.
+			Pod retries of the job would try a rejected password again
+		*/
+		backoffLimit := backupJob.Spec.BackoffLimit
+		if backoffLimit == nil && backupJob.Spec.Retry != nil {
+			backoffLimit = &noBackoff
+		}
+
 		job := &kubebatchv1.Job{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:        name,
@@ -256,16 +504,22 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 				Annotations: make(map[string]string),
 			},
 			Spec: kubebatchv1.JobSpec{
+				BackoffLimit:            backoffLimit,
+				ActiveDeadlineSeconds:   backupJob.Spec.ActiveDeadlineSeconds,
+				TTLSecondsAfterFinished: backupJob.Spec.TTLSecondsAfterFinished,
 				Template: corev1.PodTemplateSpec{
 					Spec: corev1.PodSpec{
-
-						RestartPolicy: corev1.RestartPolicyOnFailure,
+						/*
+							Failed pods are kept, the exit code of their runner
+							tells whether the run is retried
+						*/
+						RestartPolicy: corev1.RestartPolicyNever,
 						Containers: []corev1.Container{
 							{
 								Name:            imageName,
-								Image:           image,
-								ImagePullPolicy: corev1.PullAlways,
-								Env:             backupJob.Spec.Env,
+								Image:           runnerImage,
+								ImagePullPolicy: pullPolicy(runnerImage),
+								Env:             env,
 							},
 						},
 					},
@@ -273,6 +527,15 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			},
 		}
 
+		job.Annotations[annotation] = creationTime.Format(time.RFC3339)
+		if attempt > 0 {
+			job.Annotations[attemptAnnotation] = strconv.Itoa(attempt)
+		}
+
+		if err := applyPodTemplate(&job.Spec.Template, backupJob.Spec.PodTemplate); err != nil {
+			return nil, err
+		}
+
 		if err := ctrl.SetControllerReference(&dbackup, job, r.Scheme); err != nil {
 			return nil, err
 		}
@@ -280,12 +543,114 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return job, nil
 	}
 
+	/*
+		Retry the runs that wait for it, outside of the cron cadence.
+		Every retry of a run waits twice as long as the one before.
+		A retry is a run of an earlier scheduled time, it never replaces a running job
+	*/
+	var retryAfter time.Duration
+	for _, job := range runs {
+		if !retryPending[job.Name] {
+			continue
+		}
+
+		attempt := jobAttempt(job)
+		failedAt := job.CreationTimestamp.Time
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == kubebatchv1.JobFailed {
+				failedAt = condition.LastTransitionTime.Time
+			}
+		}
+
+		backoff := time.Duration(dbackup.Spec.Retry.BackoffSeconds) * time.Second << attempt
+		if wait := failedAt.Add(backoff).Sub(r.Now()); wait > 0 {
+			if retryAfter == 0 || wait < retryAfter {
+				retryAfter = wait
+			}
+			continue
+		}
+
+		if dbackup.Spec.ConcurrencyPolicy != batchv1.Allow && dbackup.Spec.ConcurrencyPolicy != "" && len(activeKubeJobs) > 0 {
+			log.V(1).Info("retry waits for active jobs", "job", job, "Active Jobs", len(activeKubeJobs))
+			continue
+		}
+
+		retry, err := createBackupJob(&dbackup, scheduledTimeOrCreation(job), attempt+1)
+		if err != nil {
+			log.Error(err, "unable to construct retry job")
+			continue
+		}
+		if err := r.Create(ctx, retry); err != nil && !apierrors.IsAlreadyExists(err) {
+			log.Error(err, "unable to create retry job", "job", retry)
+			return ctrl.Result{}, err
+		}
+
+		r.Recorder.Eventf(&dbackup, corev1.EventTypeNormal, "RetryingBackup",
+			"job %s failed with a retryable error, created %s", job.Name, retry.Name)
+		log.V(1).Info("created retry job", "job", retry)
+	}
+
+	if scheduleErr != nil {
+		log.Error(scheduleErr, "When is next schedule?")
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+
+	/*
+		Requst a reconcile on schedule time
+	*/
+	result := ctrl.Result{RequeueAfter: next.Sub(r.Now())}
+	if retryAfter > 0 && retryAfter < result.RequeueAfter {
+		result.RequeueAfter = retryAfter
+	}
+	log = log.WithValues("now", r.Now(), "next", next)
+
+	if missed.IsZero() {
+		log.V(1).Info("sleeping until next")
+		return result, nil
+	}
+
+	/*
+		Forbid deleting the running job if the policy is forbid
+	*/
+	if dbackup.Spec.ConcurrencyPolicy == batchv1.Forbid && len(activeKubeJobs) > 0 {
+		log.V(1).Info("Policy Forbids creation", "Active Jobs", len(activeKubeJobs))
+		return result, nil
+	}
+
+	/*
+		if the pocliy specifices to replace running jobs in the same time
+		the running jobs are deleted first and the new one is created once they are gone.
+		They are deleted in the foreground, a job stays active with a deletion timestamp
+		until its pods are gone, so the old dump is never running next to the new one.
+		Until then the missed run stays the same, lastScheduleTime only moves on
+		when its job is created
+	*/
+	if dbackup.Spec.ConcurrencyPolicy == batchv1.Replace && len(activeKubeJobs) > 0 {
+		for _, activeKubeJob := range activeKubeJobs {
+			if activeKubeJob.DeletionTimestamp != nil {
+				continue
+			}
+			if err := r.Delete(ctx, activeKubeJob, client.PropagationPolicy(metav1.DeletePropagationForeground)); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "unable to delete running kubernetes job", "job", activeKubeJob)
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(&dbackup, corev1.EventTypeNormal, "ReplacingJob",
+				"deleted running job %s for the run of %s", activeKubeJob.Name, missed.Format(time.RFC3339))
+		}
+
+		log.V(1).Info("waiting for replaced jobs to be deleted", "Active Jobs", len(activeKubeJobs))
+		return ctrl.Result{RequeueAfter: replaceRetryInterval}, nil
+	}
+
 	/*
 		Create a Kubernets Job object from the given specification
 	*/
-	job, err := createBackupJob(&dbackup, missed)
+	job, err := createBackupJob(&dbackup, missed, 0)
 	if err != nil {
-		log.Error(err, "unable to create job object")
+		log.Error(err, "unable to construct job object")
+		r.Recorder.Eventf(&dbackup, corev1.EventTypeWarning, "InvalidJob",
+			"unable to construct the job of the run of %s: %v", missed.Format(time.RFC3339), err)
+		return ctrl.Result{}, err
 	}
 
 	/*
@@ -297,14 +662,36 @@ func (r *DbackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	log.V(1).Info("created Job for Dbackup run", "job", job)
+
+	/*
+		The next reconcile looks for missed runs after lastScheduleTime,
+		so the run is recorded before the new job shows up in the cache
+	*/
+	dbackup.Status.LastScheduleTime = &metav1.Time{Time: missed}
+	if err := r.Status().Update(ctx, &dbackup); err != nil {
+		log.Error(err, "unable to update Dbackup status")
+		return ctrl.Result{}, err
+	}
+
 	return result, nil
 }
 
+// jobAttempt returns the retry a job runs, 0 for the first attempt of a run
+func jobAttempt(job *kubebatchv1.Job) int {
+	attempt, err := strconv.Atoi(job.Annotations[attemptAnnotation])
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
 var apiGroupVersion = batchv1.GroupVersion.String()
 var owner = ".metadata.controller"
 
 func (r *DbackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	r.Time = realTime{}
+	if r.Time == nil {
+		r.Time = realTime{}
+	}
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubebatchv1.Job{}, owner, func(rawObj client.Object) []string {
 		job := rawObj.(*kubebatchv1.Job)
 		owner := metav1.GetControllerOf(job)
@@ -0,0 +1,242 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	kubebatchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
+)
+
+const (
+	timeout  = time.Second * 10
+	interval = time.Millisecond * 250
+)
+
+var _ = Describe("Dbackup controller", func() {
+	ctx := context.Background()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		panic(err)
+	}
+
+	newDbackup := func(name string, schedule string) *batchv1.Dbackup {
+		return &batchv1.Dbackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: batchv1.DbackupSpec{
+				Schedule: schedule,
+				TimeZone: "Europe/Berlin",
+				Database: batchv1.Database{Type: "postgres"},
+				Cloud:    batchv1.Cloud{Provider: "aws", Bucket: "backups"},
+			},
+		}
+	}
+
+	/*
+		Pretend the Dbackup ran at lastSchedule.
+		The reconciler updates the status as well, so conflicts are retried
+	*/
+	setLastScheduleTime := func(name string, lastSchedule time.Time) {
+		Eventually(func() error {
+			var dbackup batchv1.Dbackup
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &dbackup); err != nil {
+				return err
+			}
+			dbackup.Status.LastScheduleTime = &metav1.Time{Time: lastSchedule}
+			return k8sClient.Status().Update(ctx, &dbackup)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// touch changes the Dbackup so it is reconciled with the current fake time
+	touch := func(name string) {
+		Eventually(func() error {
+			var dbackup batchv1.Dbackup
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &dbackup); err != nil {
+				return err
+			}
+			if dbackup.Annotations == nil {
+				dbackup.Annotations = make(map[string]string)
+			}
+			dbackup.Annotations["test/touched"] = clock.Now().Format(time.RFC3339)
+			return k8sClient.Update(ctx, &dbackup)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	scheduledMessage := func(name string) func() string {
+		return func() string {
+			var dbackup batchv1.Dbackup
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &dbackup); err != nil {
+				return ""
+			}
+			condition := meta.FindStatusCondition(dbackup.Status.Conditions, batchv1.ConditionScheduled)
+			if condition == nil || dbackup.Status.LastScheduleTime == nil {
+				return ""
+			}
+			return condition.Message
+		}
+	}
+
+	// scheduledRuns returns the scheduled-at annotations of the jobs owned by the Dbackup
+	scheduledRuns := func(name string) func() []string {
+		return func() []string {
+			var jobs kubebatchv1.JobList
+			if err := k8sClient.List(ctx, &jobs, client.InNamespace("default")); err != nil {
+				return nil
+			}
+			var runs []string
+			for _, job := range jobs.Items {
+				if owner := metav1.GetControllerOf(&job); owner != nil && owner.Name == name {
+					runs = append(runs, job.Annotations[annotation])
+				}
+			}
+			return runs
+		}
+	}
+
+	Context("when the clocks are set forward", func() {
+		It("skips the wall clock time that does not exist", func() {
+			By("standing on the day of the switch, after 02:00 became 03:00")
+			clock.Set(time.Date(2021, 3, 28, 12, 0, 0, 0, berlin))
+			Expect(k8sClient.Create(ctx, newDbackup("dst-forward", "30 2 * * *"))).Should(Succeed())
+			setLastScheduleTime("dst-forward", time.Date(2021, 3, 27, 2, 30, 0, 0, berlin))
+
+			Eventually(scheduledMessage("dst-forward"), timeout, interval).
+				Should(Equal("next run at 2021-03-29T02:30:00+02:00"))
+			Consistently(scheduledRuns("dst-forward"), time.Second*2, interval).Should(BeEmpty())
+
+			By("moving on to the next day")
+			clock.Set(time.Date(2021, 3, 29, 3, 0, 0, 0, berlin))
+			touch("dst-forward")
+
+			Eventually(scheduledRuns("dst-forward"), timeout, interval).
+				Should(ConsistOf("2021-03-29T02:30:00+02:00"))
+		})
+	})
+
+	Context("when the clocks are set back", func() {
+		It("runs the repeated wall clock time once", func() {
+			By("standing in the repeated hour after the run in summer time")
+			clock.Set(time.Date(2021, 10, 31, 1, 45, 0, 0, time.UTC))
+			Expect(k8sClient.Create(ctx, newDbackup("dst-back-once", "30 2 * * *"))).Should(Succeed())
+			setLastScheduleTime("dst-back-once", time.Date(2021, 10, 31, 0, 30, 0, 0, time.UTC))
+
+			Eventually(scheduledMessage("dst-back-once"), timeout, interval).
+				Should(Equal("next run at 2021-11-01T02:30:00+01:00"))
+			Consistently(scheduledRuns("dst-back-once"), time.Second*2, interval).Should(BeEmpty())
+		})
+
+		It("runs at the first occurrence of the repeated wall clock time", func() {
+			By("standing in the repeated hour, the run of the day is missed")
+			clock.Set(time.Date(2021, 10, 31, 1, 45, 0, 0, time.UTC))
+			Expect(k8sClient.Create(ctx, newDbackup("dst-back-first", "30 2 * * *"))).Should(Succeed())
+			setLastScheduleTime("dst-back-first", time.Date(2021, 10, 30, 2, 30, 0, 0, berlin))
+
+			Eventually(scheduledRuns("dst-back-first"), timeout, interval).
+				Should(ConsistOf("2021-10-31T02:30:00+02:00"))
+			Consistently(scheduledRuns("dst-back-first"), time.Second*2, interval).Should(HaveLen(1))
+		})
+	})
+
+	/*
+		Jobs never finish in envtest, there is no job controller.
+		The first run is still active when the second one is due
+	*/
+	runTwice := func(name string, policy batchv1.Policy) {
+		clock.Set(time.Date(2021, 6, 1, 10, 5, 30, 0, time.UTC))
+		dbackup := newDbackup(name, "*/5 * * * *")
+		dbackup.Spec.ConcurrencyPolicy = policy
+		Expect(k8sClient.Create(ctx, dbackup)).Should(Succeed())
+		setLastScheduleTime(name, time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC))
+
+		Eventually(scheduledRuns(name), timeout, interval).
+			Should(ConsistOf("2021-06-01T12:05:00+02:00"))
+
+		By("running into the next run while the first one is active")
+		clock.Set(time.Date(2021, 6, 1, 10, 10, 30, 0, time.UTC))
+		touch(name)
+	}
+
+	Context("with concurrency policy Allow", func() {
+		It("runs the next job next to the active one", func() {
+			runTwice("policy-allow", batchv1.Allow)
+
+			Eventually(scheduledRuns("policy-allow"), timeout, interval).
+				Should(ConsistOf("2021-06-01T12:05:00+02:00", "2021-06-01T12:10:00+02:00"))
+		})
+	})
+
+	Context("with concurrency policy Forbid", func() {
+		It("skips the next job while one is active", func() {
+			runTwice("policy-forbid", batchv1.Forbid)
+
+			Consistently(scheduledRuns("policy-forbid"), time.Second*2, interval).
+				Should(ConsistOf("2021-06-01T12:05:00+02:00"))
+		})
+	})
+
+	Context("with concurrency policy Replace", func() {
+		It("deletes the active job and creates the next one after it is gone", func() {
+			runTwice("policy-replace", batchv1.Replace)
+
+			/*
+				The active job is deleted in the foreground, it stays until its pods are gone.
+				envtest has no garbage collector to remove the foregroundDeletion finalizer,
+				the test removes it once the job is marked deleted
+			*/
+			name := fmt.Sprintf("policy-replace-%d", time.Date(2021, 6, 1, 10, 5, 0, 0, time.UTC).Unix())
+			var replaced kubebatchv1.Job
+			Eventually(func() []string {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &replaced); err != nil || replaced.DeletionTimestamp == nil {
+					return nil
+				}
+				return replaced.Finalizers
+			}, timeout, interval).Should(ContainElement(metav1.FinalizerDeleteDependents))
+
+			By("keeping the next run back while the replaced job is deleted")
+			Consistently(scheduledRuns("policy-replace"), time.Second*2, interval).
+				Should(ConsistOf("2021-06-01T12:05:00+02:00"))
+
+			By("removing the finalizer like the garbage collector does")
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &replaced); err != nil {
+					return err
+				}
+				replaced.Finalizers = nil
+				return k8sClient.Update(ctx, &replaced)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(scheduledRuns("policy-replace"), timeout, interval).
+				Should(ConsistOf("2021-06-01T12:10:00+02:00"))
+			Consistently(scheduledRuns("policy-replace"), time.Second*2, interval).
+				Should(ConsistOf("2021-06-01T12:10:00+02:00"))
+		})
+	})
+})
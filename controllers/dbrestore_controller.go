@@ -0,0 +1,274 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
+	kubebatchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	reference "k8s.io/client-go/tools/reference"
+)
+
+// DbrestoreReconciler reconciles a Dbrestore object
+type DbrestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Images RunnerImages
+}
+
+//+kubebuilder:rbac:groups=batch.k8s.htw-berlin.de,resources=dbrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch.k8s.htw-berlin.de,resources=dbrestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=batch.k8s.htw-berlin.de,resources=dbrestores/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch.k8s.htw-berlin.de,resources=dbackups,verbs=get;list;watch
+
+var (
+	runnerModeEnv  = "RUNNER_MODE"
+	restoreMode    = "restore"
+	restoreKeyEnv  = "RESTORE_OBJECT_KEY"
+	restoreBackoff = int32(0)
+
+	// how long to wait for the job of a deleted Dbrestore of the same name to go away
+	staleJobRetryInterval = 5 * time.Second
+)
+
+func (r *DbrestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	log := log.FromContext(ctx)
+
+	var dbrestore batchv1.Dbrestore
+	if err := r.Get(ctx, req.NamespacedName, &dbrestore); err != nil {
+		log.Error(err, "unable to fetch Dbrestore Object")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	/*
+		A restore runs exactly once, a finished restore is never touched again
+	*/
+	if dbrestore.Status.Phase == batchv1.RestoreSucceeded || dbrestore.Status.Phase == batchv1.RestoreFailed {
+		return ctrl.Result{}, nil
+	}
+
+	updateStatus := func(phase batchv1.RestorePhase, message string) (ctrl.Result, error) {
+		dbrestore.Status.Phase = phase
+		dbrestore.Status.Message = message
+		if err := r.Status().Update(ctx, &dbrestore); err != nil {
+			log.Error(err, "unable to update Dbrestore status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	/*
+		The restore job has the name of the Dbrestore object,
+		so there is never more than one restore running for it
+	*/
+	var job kubebatchv1.Job
+	err := r.Get(ctx, types.NamespacedName{Namespace: dbrestore.Namespace, Name: dbrestore.Name}, &job)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "unable to fetch restore Job")
+		return ctrl.Result{}, err
+	}
+
+	/*
+		A Dbrestore deleted and created again with the same name finds the job
+		of the old one until the garbage collector removed it. Its result is not ours
+	*/
+	if err == nil && !metav1.IsControlledBy(&job, &dbrestore) {
+		if _, err := updateStatus(batchv1.RestorePending, fmt.Sprintf("waiting for Job %q of another restore to be deleted", job.Name)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: staleJobRetryInterval}, nil
+	}
+
+	if err == nil {
+		jobReference, err := reference.GetReference(r.Scheme, &job)
+		if err != nil {
+			log.Error(err, "No reference to restore job", "job", &job)
+		} else {
+			dbrestore.Status.Job = jobReference
+		}
+
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case kubebatchv1.JobComplete:
+				dbrestore.Status.CompletionTime = job.Status.CompletionTime
+				return updateStatus(batchv1.RestoreSucceeded, "restore completed")
+			case kubebatchv1.JobFailed:
+				dbrestore.Status.CompletionTime = &condition.LastTransitionTime
+				return updateStatus(batchv1.RestoreFailed, condition.Message)
+			}
+		}
+
+		dbrestore.Status.StartTime = job.Status.StartTime
+		return updateStatus(batchv1.RestoreRunning, "restore job is running")
+	}
+
+	/*
+		Resolve the Dbackup the restore refers to.
+		Its cloud specification and env are the base for the restore job
+	*/
+	var env []corev1.EnvVar
+	cloud := dbrestore.Spec.Cloud
+	runnerOverride := dbrestore.Spec.RunnerImage
+	podTemplate := dbrestore.Spec.PodTemplate
+	encryption := dbrestore.Spec.Encryption
+	checksum := dbrestore.Spec.Checksum
+	objectKey := dbrestore.Spec.ObjectKey
+	if dbrestore.Spec.BackupRef != nil {
+		var dbackup batchv1.Dbackup
+		if err := r.Get(ctx, types.NamespacedName{Namespace: dbrestore.Namespace, Name: dbrestore.Spec.BackupRef.Name}, &dbackup); err != nil {
+			if apierrors.IsNotFound(err) {
+				return updateStatus(batchv1.RestoreFailed, fmt.Sprintf("Dbackup %q not found", dbrestore.Spec.BackupRef.Name))
+			}
+			log.Error(err, "unable to fetch referenced Dbackup")
+			return ctrl.Result{}, err
+		}
+		if cloud == nil {
+			cloud = &dbackup.Spec.Cloud
+		}
+		env = append(env, dbackup.Spec.Env...)
+		if runnerOverride == "" {
+			runnerOverride = dbackup.Spec.RunnerImage
+		}
+		if podTemplate == nil {
+			podTemplate = dbackup.Spec.PodTemplate
+		}
+		if encryption == nil {
+			encryption = dbackup.Spec.Encryption
+		}
+
+		/*
+			Without a key the last backup of the Dbackup is restored.
+			The runner can not find it by the target database, which may have another name.
+			A run of all databases has no single dump to restore, its dumps are picked by key
+		*/
+		last := dbackup.Status.LastBackup
+		if objectKey == "" && last != nil && dbackup.Spec.Database.AllDatabases == nil {
+			objectKey = last.Key
+		}
+		if checksum == "" && last != nil && last.Key == objectKey {
+			checksum = last.Checksum
+		}
+	}
+
+	if cloud == nil {
+		return updateStatus(batchv1.RestoreFailed, "either backupRef or cloud has to be set")
+	}
+
+	if err := checkSecrets(ctx, r.Client, dbrestore.Namespace, dbrestore.Spec.Database, *cloud, encryption); err != nil {
+		invalid, ok := err.(*secretError)
+		if !ok {
+			log.Error(err, "unable to check referenced Secrets")
+			return ctrl.Result{}, err
+		}
+		if _, err := updateStatus(batchv1.RestorePending, invalid.message); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: secretRetryInterval}, nil
+	}
+
+	/*
+		function stored in a variable to create the restore job.
+		A failed restore is not retried, rerunning it over a half restored database
+		does more harm than good
+	*/
+	createRestoreJob := func(restore *batchv1.Dbrestore, cloud *batchv1.Cloud) (*kubebatchv1.Job, error) {
+		env = append(env, restore.Spec.Env...)
+		env = append(env, specEnv(restore.Spec.Database, *cloud)...)
+		env = append(env, encryptionEnv(encryption)...)
+		if checksum != "" {
+			env = append(env, corev1.EnvVar{Name: checksumEnv, Value: checksum})
+		}
+		env = append(env,
+			corev1.EnvVar{Name: runnerModeEnv, Value: restoreMode},
+			corev1.EnvVar{Name: restoreKeyEnv, Value: objectKey},
+		)
+
+		runnerImage := r.Images.Image(restore.Spec.Database.Type, cloud.Provider, runnerOverride)
+
+		job := &kubebatchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        restore.Name,
+				Namespace:   restore.Namespace,
+				Labels:      make(map[string]string),
+				Annotations: make(map[string]string),
+			},
+			Spec: kubebatchv1.JobSpec{
+				BackoffLimit: &restoreBackoff,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyNever,
+						Containers: []corev1.Container{
+							{
+								Name:            imageName,
+								Image:           runnerImage,
+								ImagePullPolicy: pullPolicy(runnerImage),
+								Env:             env,
+							},
+						},
+					},
+				},
+			},
+		}
+
+		if err := applyPodTemplate(&job.Spec.Template, podTemplate); err != nil {
+			return nil, err
+		}
+
+		if err := ctrl.SetControllerReference(restore, job, r.Scheme); err != nil {
+			return nil, err
+		}
+
+		return job, nil
+	}
+
+	restoreJob, err := createRestoreJob(&dbrestore, cloud)
+	if err != nil {
+		log.Error(err, "unable to create restore job object")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Create(ctx, restoreJob); err != nil {
+		log.Error(err, "unable to create Job for Dbrestore", "job", restoreJob)
+		return ctrl.Result{}, err
+	}
+
+	log.V(1).Info("created Job for Dbrestore", "job", restoreJob)
+	return updateStatus(batchv1.RestorePending, "restore job created")
+}
+
+func (r *DbrestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&batchv1.Dbrestore{}).
+		Owns(&kubebatchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultRunnerImage runs every database and provider when nothing else is configured
+const DefaultRunnerImage = "ahmedmahmoud25/dbackup-postgres-aws:master"
+
+// RunnerImages maps database type and cloud provider to the runner image.
+// Air-gapped clusters point it at a mirror, ideally pinned by digest:
+//
+//	default: registry.local/dbackup-runner@sha256:...
+//	images:
+//	  postgres/aws: registry.local/dbackup-postgres-aws@sha256:...
+//	  mysql/gcp: registry.local/dbackup-mysql-gcp:1.2.0
+type RunnerImages struct {
+	// Image of every combination not in Images
+	Default string `json:"default,omitempty"`
+
+	// Images by <database>/<provider>
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// LoadRunnerImages reads the runner images from a file, usually mounted from a ConfigMap.
+// A missing file leaves the images unchanged
+func LoadRunnerImages(path string, images *RunnerImages) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var loaded RunnerImages
+	if err := yaml.UnmarshalStrict(data, &loaded); err != nil {
+		return fmt.Errorf("invalid runner images in %s: %v", path, err)
+	}
+	if loaded.Default != "" {
+		images.Default = loaded.Default
+	}
+	if images.Images == nil {
+		images.Images = make(map[string]string)
+	}
+	for key, image := range loaded.Images {
+		images.Images[key] = image
+	}
+	return nil
+}
+
+// Image returns the runner image of the database type and cloud provider.
+// An override set on the resource wins over the registry
+func (i RunnerImages) Image(database, provider, override string) string {
+	if override != "" {
+		return override
+	}
+	if image, ok := i.Images[database+"/"+provider]; ok {
+		return image
+	}
+	if i.Default != "" {
+		return i.Default
+	}
+	return DefaultRunnerImage
+}
+
+// pullPolicy pulls tags on every run, they can move.
+// An image pinned by digest never changes, the node's copy is good
+func pullPolicy(image string) corev1.PullPolicy {
+	if strings.Contains(image, "@sha256:") {
+		return corev1.PullIfNotPresent
+	}
+	return corev1.PullAlways
+}
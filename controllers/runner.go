@@ -0,0 +1,434 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
+	kubebatchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+/*
+	The runner image is configured through env only.
+	These are the variables the operator sets from the typed spec
+*/
+
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=pods,verbs=list
+
+var (
+	databaseTypeEnv = "DATABASE_TYPE"
+	providerEnv     = "CLOUD_PROVIDER"
+	bucketEnv       = "STORAGE_BUCKET"
+	regionEnv       = "STORAGE_REGION"
+	prefixEnv       = "STORAGE_PREFIX"
+	endpointEnv     = "STORAGE_ENDPOINT"
+	dbackupUIDEnv   = "DBACKUP_UID"
+	checksumEnv     = "EXPECTED_CHECKSUM"
+
+	// how long to wait for a missing Secret before checking again
+	secretRetryInterval = time.Minute
+
+	// result of a finished backup, copied from the termination message of its pod
+	resultAnnotation = "batch.k8s.htw-berlin.de/result"
+
+	// exit code of the runner of a finished job, copied from its pod
+	exitCodeAnnotation = "batch.k8s.htw-berlin.de/exit-code"
+
+	// exit code of a runner that failed for a reason that may be gone on the next try.
+	// Failed credentials or a broken configuration exit differently, they fail the same way again
+	retryableExitCode = int32(75)
+
+	// checksum of a dump as reported by the runner, restores are verified against it
+	checksumPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// retentionEnv configures the prune step that runs after every upload
+func retentionEnv(retention *batchv1.Retention) []corev1.EnvVar {
+	if retention == nil {
+		return nil
+	}
+
+	rules := []struct {
+		name  string
+		value int32
+	}{
+		{"RETENTION_KEEP_LAST", retention.KeepLast},
+		{"RETENTION_KEEP_DAILY", retention.KeepDaily},
+		{"RETENTION_KEEP_WEEKLY", retention.KeepWeekly},
+		{"RETENTION_KEEP_MONTHLY", retention.KeepMonthly},
+		{"RETENTION_KEEP_YEARLY", retention.KeepYearly},
+	}
+
+	var env []corev1.EnvVar
+	for _, rule := range rules {
+		env = append(env, corev1.EnvVar{Name: rule.name, Value: strconv.Itoa(int(rule.value))})
+	}
+	return env
+}
+
+// compressionEnv selects the compression of the dump stream
+func compressionEnv(compression *batchv1.Compression) []corev1.EnvVar {
+	if compression == nil {
+		return nil
+	}
+
+	env := []corev1.EnvVar{{Name: "COMPRESSION", Value: compression.Algorithm}}
+	if compression.Level != nil {
+		env = append(env, corev1.EnvVar{Name: "COMPRESSION_LEVEL", Value: strconv.Itoa(int(*compression.Level))})
+	}
+	return env
+}
+
+// secretKey maps a key of a referenced Secret to the runner env it is mounted as
+type secretKey struct {
+	key      string
+	env      string
+	optional bool
+}
+
+// connectionKeys are the keys of a connection Secret.
+// They are mounted with the prefix of the database type, e.g. POSTGRES_HOST
+func connectionKeys(databaseType string) []secretKey {
+	prefix := strings.ToUpper(databaseType) + "_"
+	return []secretKey{
+		{key: "host", env: prefix + "HOST"},
+		{key: "port", env: prefix + "PORT", optional: true},
+		{key: "database", env: prefix + "DATABASE"},
+		{key: "username", env: prefix + "USERNAME"},
+		{key: "password", env: prefix + "PASSWORD"},
+	}
+}
+
+// credentialKeys are the keys of a credentials Secret of the cloud provider
+func credentialKeys(provider string) []secretKey {
+	switch provider {
+	case "aws":
+		return []secretKey{
+			{key: "accessKeyId", env: "AWS_ACCESS_KEY_ID"},
+			{key: "secretAccessKey", env: "AWS_SECRET_ACCESS_KEY"},
+		}
+	case "azure":
+		return []secretKey{
+			{key: "accountName", env: "AZURE_STORAGE_ACCOUNT"},
+			{key: "accountKey", env: "AZURE_STORAGE_KEY"},
+		}
+	case "gcp":
+		return []secretKey{
+			{key: "serviceAccount.json", env: "GOOGLE_APPLICATION_CREDENTIALS_JSON"},
+		}
+	}
+	return nil
+}
+
+// encryptionKeys are the keys of an encryption key Secret
+var encryptionKeys = []secretKey{
+	{key: "key", env: "ENCRYPTION_KEY"},
+	{key: "keyId", env: "ENCRYPTION_KEY_ID", optional: true},
+}
+
+// encryptionEnv hands the key of the dumps to the runner,
+// which encrypts backups and decrypts restores with it
+func encryptionEnv(encryption *batchv1.Encryption) []corev1.EnvVar {
+	if encryption == nil {
+		return nil
+	}
+	return secretEnv(&encryption.KeySecretRef, encryptionKeys)
+}
+
+// secretEnv references every key of the Secret, the values never show up in the Job spec
+func secretEnv(ref *corev1.LocalObjectReference, keys []secretKey) []corev1.EnvVar {
+	if ref == nil {
+		return nil
+	}
+
+	var env []corev1.EnvVar
+	for _, k := range keys {
+		optional := k.optional
+		env = append(env, corev1.EnvVar{
+			Name: k.env,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: *ref,
+					Key:                  k.key,
+					Optional:             &optional,
+				},
+			},
+		})
+	}
+	return env
+}
+
+// specEnv turns the database and cloud specification into runner env.
+// It is appended after the user env, so the typed spec wins
+// over a variable of the same name
+func specEnv(database batchv1.Database, cloud batchv1.Cloud) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: databaseTypeEnv, Value: database.Type},
+		{Name: providerEnv, Value: cloud.Provider},
+		{Name: bucketEnv, Value: cloud.Bucket},
+	}
+
+	/*
+		optional fields are only set when given, so an empty field
+		does not hide a value the user still passes as raw env
+	*/
+	if cloud.Region != "" {
+		env = append(env, corev1.EnvVar{Name: regionEnv, Value: cloud.Region})
+	}
+	if cloud.Prefix != "" {
+		env = append(env, corev1.EnvVar{Name: prefixEnv, Value: cloud.Prefix})
+	}
+	if cloud.Endpoint != "" {
+		env = append(env, corev1.EnvVar{Name: endpointEnv, Value: cloud.Endpoint})
+	}
+
+	filters := []struct {
+		name    string
+		entries []string
+	}{
+		{"DUMP_INCLUDE_SCHEMAS", database.IncludeSchemas},
+		{"DUMP_EXCLUDE_SCHEMAS", database.ExcludeSchemas},
+		{"DUMP_INCLUDE_TABLES", database.IncludeTables},
+		{"DUMP_EXCLUDE_TABLES", database.ExcludeTables},
+		{"DUMP_EXCLUDE_TABLE_DATA", database.ExcludeTableData},
+	}
+	for _, filter := range filters {
+		if len(filter.entries) > 0 {
+			env = append(env, corev1.EnvVar{Name: filter.name, Value: strings.Join(filter.entries, ",")})
+		}
+	}
+
+	if all := database.AllDatabases; all != nil {
+		env = append(env, corev1.EnvVar{Name: "ALL_DATABASES", Value: "true"})
+		if len(all.Include) > 0 {
+			env = append(env, corev1.EnvVar{Name: "ALL_DATABASES_INCLUDE", Value: strings.Join(all.Include, ",")})
+		}
+		if len(all.Exclude) > 0 {
+			env = append(env, corev1.EnvVar{Name: "ALL_DATABASES_EXCLUDE", Value: strings.Join(all.Exclude, ",")})
+		}
+	}
+
+	if options := database.Postgres; options != nil {
+		if options.Format != "" {
+			env = append(env, corev1.EnvVar{Name: "POSTGRES_FORMAT", Value: options.Format})
+		}
+		if options.Jobs > 0 {
+			env = append(env, corev1.EnvVar{Name: "POSTGRES_JOBS", Value: strconv.Itoa(int(options.Jobs))})
+		}
+	}
+
+	env = append(env, secretEnv(database.ConnectionSecretRef, connectionKeys(database.Type))...)
+	env = append(env, secretEnv(cloud.CredentialsSecretRef, credentialKeys(cloud.Provider))...)
+	return env
+}
+
+// secretError tells why a referenced Secret can not be used by the runner
+type secretError struct {
+	reason  string
+	message string
+}
+
+func (e *secretError) Error() string {
+	return e.message
+}
+
+// checkSecrets makes sure every referenced Secret exists and holds all required keys,
+// otherwise the runner pod would be stuck in CreateContainerConfigError
+func checkSecrets(ctx context.Context, c client.Client, namespace string, database batchv1.Database, cloud batchv1.Cloud, encryption *batchv1.Encryption) error {
+	check := func(ref *corev1.LocalObjectReference, keys []secretKey) error {
+		if ref == nil {
+			return nil
+		}
+
+		var secret corev1.Secret
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return &secretError{reason: "SecretNotFound", message: fmt.Sprintf("Secret %q not found", ref.Name)}
+			}
+			return err
+		}
+
+		var missing []string
+		for _, k := range keys {
+			if _, ok := secret.Data[k.key]; !ok && !k.optional {
+				missing = append(missing, k.key)
+			}
+		}
+		if len(missing) > 0 {
+			return &secretError{reason: "SecretKeyMissing", message: fmt.Sprintf("Secret %q is missing keys %s", ref.Name, strings.Join(missing, ", "))}
+		}
+		return nil
+	}
+
+	if err := check(database.ConnectionSecretRef, connectionKeys(database.Type)); err != nil {
+		return err
+	}
+	if err := check(cloud.CredentialsSecretRef, credentialKeys(cloud.Provider)); err != nil {
+		return err
+	}
+	if encryption != nil {
+		return check(&encryption.KeySecretRef, encryptionKeys)
+	}
+	return nil
+}
+
+// runnerTermination returns how the runner container of the job's most recent pod terminated.
+// A job without a terminated runner gives nil
+func runnerTermination(ctx context.Context, c client.Client, job *kubebatchv1.Job) (*corev1.ContainerStateTerminated, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return nil, err
+	}
+
+	var last *corev1.ContainerStateTerminated
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if status.Name == imageName && terminated != nil && (last == nil || last.FinishedAt.Before(&terminated.FinishedAt)) {
+				last = terminated
+			}
+		}
+	}
+	return last, nil
+}
+
+// annotateJob caches what was read from the job's pods on the job,
+// pods are garbage collected long before the job is
+func annotateJob(ctx context.Context, c client.Client, job *kubebatchv1.Job, key, value string) error {
+	patch := client.MergeFrom(job.DeepCopy())
+	if job.Annotations == nil {
+		job.Annotations = make(map[string]string)
+	}
+	job.Annotations[key] = value
+	return c.Patch(ctx, job, patch)
+}
+
+// jobResult returns the result the runner wrote to the termination message of the job's pod.
+// A job without a readable result gives nil
+func jobResult(ctx context.Context, c client.Client, job *kubebatchv1.Job) (*batchv1.BackupResult, error) {
+	message, cached := job.Annotations[resultAnnotation]
+
+	if !cached {
+		terminated, err := runnerTermination(ctx, c, job)
+		if err != nil {
+			return nil, err
+		}
+		if terminated == nil || terminated.ExitCode != 0 || terminated.Message == "" {
+			return nil, nil
+		}
+		message = terminated.Message
+	}
+
+	result := &batchv1.BackupResult{}
+	if err := json.Unmarshal([]byte(message), result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %v", job.Name, err)
+	}
+	if !checksumPattern.MatchString(result.Checksum) {
+		return nil, fmt.Errorf("invalid checksum %q in result of job %s", result.Checksum, job.Name)
+	}
+	result.Job = job.Name
+
+	if !cached {
+		if err := annotateJob(ctx, c, job, resultAnnotation, message); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// jobExitCode returns the exit code of the runner of a finished job.
+// ok is false when the job has no terminated runner
+func jobExitCode(ctx context.Context, c client.Client, job *kubebatchv1.Job) (code int32, ok bool, err error) {
+	if raw, cached := job.Annotations[exitCodeAnnotation]; cached {
+		parsed, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid exit code of job %s: %v", job.Name, err)
+		}
+		return int32(parsed), true, nil
+	}
+
+	terminated, err := runnerTermination(ctx, c, job)
+	if err != nil || terminated == nil {
+		return 0, false, err
+	}
+
+	if err := annotateJob(ctx, c, job, exitCodeAnnotation, strconv.Itoa(int(terminated.ExitCode))); err != nil {
+		return 0, false, err
+	}
+	return terminated.ExitCode, true, nil
+}
+
+// applyPodTemplate strategically merges the overrides onto the pod template of a job.
+// The runner container is matched by its name, so only its resources and security context change
+func applyPodTemplate(template *corev1.PodTemplateSpec, override *batchv1.PodTemplate) error {
+	if override == nil {
+		return nil
+	}
+
+	runner := corev1.Container{Name: imageName, SecurityContext: override.ContainerSecurityContext}
+	if override.Resources != nil {
+		runner.Resources = *override.Resources
+	}
+
+	patch := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      override.Labels,
+			Annotations: override.Annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers:         []corev1.Container{runner},
+			NodeSelector:       override.NodeSelector,
+			Tolerations:        override.Tolerations,
+			Affinity:           override.Affinity,
+			ServiceAccountName: override.ServiceAccountName,
+			ImagePullSecrets:   override.ImagePullSecrets,
+			SecurityContext:    override.SecurityContext,
+		},
+	}
+
+	original, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	merged, err := strategicpatch.StrategicMergePatch(original, patchJSON, corev1.PodTemplateSpec{})
+	if err != nil {
+		return fmt.Errorf("unable to merge the pod template: %v", err)
+	}
+
+	*template = corev1.PodTemplateSpec{}
+	return json.Unmarshal(merged, template)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
+	cron "github.com/robfig/cron"
+)
+
+// maxMissedSchedules bounds the cron ticks walked one by one in getNextSchedule
+var maxMissedSchedules = 100
+
+// zonedSchedule evaluates a cron schedule in the wall clock of a time zone.
+// A wall clock time skipped by the switch to daylight saving time is not run that day.
+// The wall clock times repeated by the switch back are run once, at their first occurrence:
+// a tick is only taken when its wall clock is later than the one of the time it follows
+type zonedSchedule struct {
+	cron.Schedule
+	location *time.Location
+}
+
+func (s zonedSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location)
+	next := s.Schedule.Next(t)
+	for !next.IsZero() && !wallClock(next).After(wallClock(t)) {
+		next = s.Schedule.Next(next)
+	}
+	return next
+}
+
+// wallClock is the date and time shown on a clock of the time zone of t
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// jitteredSchedule runs every tick of a schedule a fixed offset later
+type jitteredSchedule struct {
+	cron.Schedule
+	offset time.Duration
+}
+
+func (s jitteredSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.Add(-s.offset)).Add(s.offset)
+}
+
+// parseSchedule parses the resolved cron schedule of the Dbackup in its time zone
+func parseSchedule(dbackup *batchv1.Dbackup) (cron.Schedule, error) {
+	resolved, offset, err := dbackup.ResolveSchedule()
+	if err != nil {
+		return nil, err
+	}
+
+	sched, err := cron.ParseStandard(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("Unparseable schedule %q: %v", dbackup.Spec.Schedule, err)
+	}
+
+	location := time.Local
+	if dbackup.Spec.TimeZone != "" {
+		location, err = time.LoadLocation(dbackup.Spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown time zone %q: %v", dbackup.Spec.TimeZone, err)
+		}
+	}
+	sched = zonedSchedule{Schedule: sched, location: location}
+
+	if offset > 0 {
+		sched = jitteredSchedule{Schedule: sched, offset: offset}
+	}
+	return sched, nil
+}
+
+// getNextSchedule returns the most recent missed run and the next run of the Dbackup.
+// Runs are looked for after the last scheduled run or, for a new Dbackup, after its creation.
+// Runs older than startingDeadlineSeconds are never started.
+// tooMany reports that more than maxMissedSchedules runs were missed
+func getNextSchedule(dbackup *batchv1.Dbackup, now time.Time) (lastMissed time.Time, next time.Time, tooMany bool, err error) {
+	sched, err := parseSchedule(dbackup)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	earliest := dbackup.ObjectMeta.CreationTimestamp.Time
+	if dbackup.Status.LastScheduleTime != nil {
+		earliest = dbackup.Status.LastScheduleTime.Time
+	}
+
+	if deadline := dbackup.Spec.StartingDeadlineSeconds; deadline != nil {
+		schedulingDeadline := now.Add(-time.Second * time.Duration(*deadline))
+		if schedulingDeadline.After(earliest) {
+			earliest = schedulingDeadline
+		}
+	}
+
+	if earliest.After(now) {
+		return time.Time{}, sched.Next(now), false, nil
+	}
+
+	/*
+		Walk the missed ticks up to the cap.
+		Past the cap only the most recent tick matters, it is searched for
+		starting one largest gap between the walked ticks before now
+	*/
+	var gap time.Duration
+	missed := 0
+	for t := sched.Next(earliest); !t.After(now); t = sched.Next(t) {
+		if !lastMissed.IsZero() && t.Sub(lastMissed) > gap {
+			gap = t.Sub(lastMissed)
+		}
+		lastMissed = t
+
+		missed++
+		if missed > maxMissedSchedules {
+			tooMany = true
+			for t := sched.Next(now.Add(-gap)); !t.After(now); t = sched.Next(t) {
+				lastMissed = t
+			}
+			break
+		}
+	}
+	return lastMissed, sched.Next(now), tooMany, nil
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	batchv1 "github.com/ahmedmahmo/discovery-operator/api/v1"
+)
+
+/*
+	The schedule tests need no API server, unlike the suite they run without envtest:
+	go test ./controllers -run 'TestZonedSchedule|TestGetNextSchedule'
+*/
+
+func scheduledDbackup(schedule, timeZone string, lastSchedule *time.Time) *batchv1.Dbackup {
+	dbackup := &batchv1.Dbackup{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.Time{Time: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		Spec: batchv1.DbackupSpec{Schedule: schedule, TimeZone: timeZone},
+	}
+	if lastSchedule != nil {
+		dbackup.Status.LastScheduleTime = &metav1.Time{Time: *lastSchedule}
+	}
+	return dbackup
+}
+
+func TestZonedSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		schedule string
+		from     time.Time
+		ticks    []string
+	}{
+		{
+			name:     "skipped wall clock time is not run that day",
+			schedule: "30 2 * * *",
+			from:     time.Date(2021, 3, 27, 2, 30, 0, 0, berlin),
+			ticks:    []string{"2021-03-29T02:30:00+02:00", "2021-03-30T02:30:00+02:00"},
+		},
+		{
+			name:     "repeated wall clock time is run at its first occurrence",
+			schedule: "30 2 * * *",
+			from:     time.Date(2021, 10, 30, 2, 30, 0, 0, berlin),
+			ticks:    []string{"2021-10-31T02:30:00+02:00", "2021-11-01T02:30:00+01:00"},
+		},
+		{
+			name:     "two ticks in the repeated hour are run once each",
+			schedule: "*/30 2 * * *",
+			from:     time.Date(2021, 10, 31, 0, 0, 0, 0, berlin),
+			ticks:    []string{"2021-10-31T02:00:00+02:00", "2021-10-31T02:30:00+02:00", "2021-11-01T02:00:00+01:00"},
+		},
+		{
+			name:     "ticks after the repeated hour are run",
+			schedule: "*/30 2-3 * * *",
+			from:     time.Date(2021, 10, 31, 2, 30, 0, 0, berlin),
+			ticks:    []string{"2021-10-31T03:00:00+01:00", "2021-10-31T03:30:00+01:00", "2021-11-01T02:00:00+01:00"},
+		},
+		{
+			name:     "hourly schedule runs the repeated hour once",
+			schedule: "15 * * * *",
+			from:     time.Date(2021, 10, 31, 1, 30, 0, 0, berlin),
+			ticks:    []string{"2021-10-31T02:15:00+02:00", "2021-10-31T03:15:00+01:00"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sched, err := parseSchedule(scheduledDbackup(test.schedule, "Europe/Berlin", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ticks []string
+			for tick := sched.Next(test.from); len(ticks) < len(test.ticks); tick = sched.Next(tick) {
+				ticks = append(ticks, tick.Format(time.RFC3339))
+			}
+			for i := range ticks {
+				if ticks[i] != test.ticks[i] {
+					t.Errorf("ticks %v, want %v", ticks, test.ticks)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetNextSchedule(t *testing.T) {
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2021, 6, 1, hour, minute, second, 0, time.UTC)
+	}
+	seconds := func(s int64) *int64 {
+		return &s
+	}
+	overCap := at(10, 0, 0).Add(time.Duration(maxMissedSchedules+1)*time.Minute + 30*time.Second)
+	atCap := at(10, 0, 0).Add(time.Duration(maxMissedSchedules)*time.Minute + 30*time.Second)
+	idle := at(10, 0, 0).AddDate(0, 0, -30)
+
+	tests := []struct {
+		name         string
+		schedule     string
+		lastSchedule time.Time
+		deadline     *int64
+		now          time.Time
+		missed       time.Time
+		next         time.Time
+		tooMany      bool
+	}{
+		{
+			name:         "nothing missed",
+			schedule:     "0 * * * *",
+			lastSchedule: at(10, 0, 0),
+			now:          at(10, 30, 0),
+			next:         at(11, 0, 0),
+		},
+		{
+			name:         "the most recent of a few missed runs",
+			schedule:     "* * * * *",
+			lastSchedule: at(10, 0, 0),
+			now:          at(10, 5, 30),
+			missed:       at(10, 5, 0),
+			next:         at(10, 6, 0),
+		},
+		{
+			name:         "as many missed runs as the cap",
+			schedule:     "* * * * *",
+			lastSchedule: at(10, 0, 0),
+			now:          atCap,
+			missed:       atCap.Truncate(time.Minute),
+			next:         atCap.Truncate(time.Minute).Add(time.Minute),
+		},
+		{
+			name:         "one missed run above the cap",
+			schedule:     "* * * * *",
+			lastSchedule: at(10, 0, 0),
+			now:          overCap,
+			missed:       overCap.Truncate(time.Minute),
+			next:         overCap.Truncate(time.Minute).Add(time.Minute),
+			tooMany:      true,
+		},
+		{
+			name:         "long idle per minute schedule gives the latest tick",
+			schedule:     "* * * * *",
+			lastSchedule: idle,
+			now:          at(10, 0, 30),
+			missed:       at(10, 0, 0),
+			next:         at(10, 1, 0),
+			tooMany:      true,
+		},
+		{
+			name:         "deadline keeps the runs within it",
+			schedule:     "*/5 * * * *",
+			lastSchedule: at(10, 0, 0),
+			deadline:     seconds(180),
+			now:          at(10, 22, 0),
+			missed:       at(10, 20, 0),
+			next:         at(10, 25, 0),
+		},
+		{
+			name:         "deadline excludes every missed run",
+			schedule:     "0 * * * *",
+			lastSchedule: at(8, 0, 0),
+			deadline:     seconds(60),
+			now:          at(10, 59, 30),
+			next:         at(11, 0, 0),
+		},
+		{
+			name:         "deadline ends the catch up of a long idle schedule",
+			schedule:     "* * * * *",
+			lastSchedule: idle,
+			deadline:     seconds(90),
+			now:          at(10, 0, 30),
+			missed:       at(10, 0, 0),
+			next:         at(10, 1, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbackup := scheduledDbackup(test.schedule, "UTC", &test.lastSchedule)
+			dbackup.Spec.StartingDeadlineSeconds = test.deadline
+
+			missed, next, tooMany, err := getNextSchedule(dbackup, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !missed.Equal(test.missed) || !next.Equal(test.next) || tooMany != test.tooMany {
+				t.Errorf("missed %s, next %s, too many %t, want %s, %s, %t",
+					missed, next, tooMany, test.missed, test.next, test.tooMany)
+			}
+		})
+	}
+}
+
+func TestGetNextScheduleOfNewDbackup(t *testing.T) {
+	dbackup := scheduledDbackup("0 * * * *", "UTC", nil)
+	now := dbackup.CreationTimestamp.Add(150 * time.Minute)
+
+	missed, _, tooMany, err := getNextSchedule(dbackup, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dbackup.CreationTimestamp.Add(2 * time.Hour); !missed.Equal(want) || tooMany {
+		t.Errorf("missed %s, too many %t, want %s", missed, tooMany, want)
+	}
+}
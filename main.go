@@ -20,14 +20,19 @@ import (
 	"flag"
 	"os"
 
+	// Embed the time zone database, the distroless image does not ship one
+	_ "time/tzdata"
+
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -52,11 +57,18 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var runnerImages controllers.RunnerImages
+	var runnerImagesFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&runnerImages.Default, "runner-image", controllers.DefaultRunnerImage,
+		"The runner image of every database type and cloud provider without an image of its own.")
+	flag.StringVar(&runnerImagesFile, "runner-images-file", "",
+		"A YAML file with the runner images by database type and cloud provider, usually mounted from a ConfigMap. "+
+			"Its default overrides --runner-image.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +77,13 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if runnerImagesFile != "" {
+		if err := controllers.LoadRunnerImages(runnerImagesFile, &runnerImages); err != nil {
+			setupLog.Error(err, "unable to load runner images")
+			os.Exit(1)
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -72,6 +91,9 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "017863e5.k8s.htw-berlin.de",
+		// Secrets are only read before a run is created and runner pods once it
+		// finishes, caching them would watch every Secret and pod of the cluster
+		ClientDisableCacheFor: []client.Object{&corev1.Secret{}, &corev1.Pod{}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -79,10 +101,26 @@ func main() {
 	}
 
 	if err = (&controllers.DbackupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("dbackup-controller"),
+		Images:   runnerImages,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Dbackup")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&batchv1.Dbackup{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Dbackup")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.DbrestoreReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		Images: runnerImages,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Dbackup")
+		setupLog.Error(err, "unable to create controller", "controller", "Dbrestore")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
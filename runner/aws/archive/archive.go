@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write streams the files of dir as tar archive to w.
+// A directory dump of pg_dump is flat, subdirectories are not archived
+func Write(dir string, w io.Writer) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(w)
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		header, err := tar.FileInfoHeader(file, "")
+		if err != nil {
+			return err
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyFile(archive, filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Extract writes the files of the tar archive read from r into dir.
+// Entries with a path are rejected, they could point out of dir
+func Extract(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg || filepath.Base(header.Name) != header.Name || header.Name == ".." {
+			return fmt.Errorf("unexpected entry %q in dump archive", header.Name)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, header.Name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, archive)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Algorithm compresses the dumps while they are streamed to the bucket
+type Algorithm struct {
+	// Name as set in the Dbackup spec
+	Name string
+
+	// Extension appended to the key of the dump
+	Extension string
+
+	// Content-Encoding stored with the dump
+	ContentEncoding string
+
+	// first bytes of every stream the algorithm writes
+	magic []byte
+
+	// writer compresses at level, 0 is the default level of the algorithm
+	writer func(w io.Writer, level int) (io.WriteCloser, error)
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// NewWriter compresses everything written to it into w.
+// Closing it flushes the compressed stream, w is not closed
+func (a *Algorithm) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return a.writer(w, level)
+}
+
+// lz4 levels by the level of the spec
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+var (
+	none = &Algorithm{
+		Name: "none",
+		writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+	}
+
+	algorithms = []*Algorithm{
+		{
+			Name:            "gzip",
+			Extension:       ".gz",
+			ContentEncoding: "gzip",
+			magic:           []byte{0x1f, 0x8b},
+			writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+				if level == 0 {
+					level = gzip.DefaultCompression
+				}
+				return gzip.NewWriterLevel(w, level)
+			},
+			reader: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			Name:            "zstd",
+			Extension:       ".zst",
+			ContentEncoding: "zstd",
+			magic:           []byte{0x28, 0xb5, 0x2f, 0xfd},
+			writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+				var options []zstd.EOption
+				if level != 0 {
+					options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+				}
+				return zstd.NewWriter(w, options...)
+			},
+			reader: func(r io.Reader) (io.ReadCloser, error) {
+				decoder, err := zstd.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+				return decoder.IOReadCloser(), nil
+			},
+		},
+		{
+			Name:            "lz4",
+			Extension:       ".lz4",
+			ContentEncoding: "x-lz4",
+			magic:           []byte{0x04, 0x22, 0x4d, 0x18},
+			writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+				writer := lz4.NewWriter(w)
+				if level != 0 {
+					if level < 1 || level > len(lz4Levels) {
+						return nil, fmt.Errorf("lz4 level %d is not between 1 and %d", level, len(lz4Levels))
+					}
+					if err := writer.Apply(lz4.CompressionLevelOption(lz4Levels[level-1])); err != nil {
+						return nil, err
+					}
+				}
+				return writer, nil
+			},
+			reader: func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(lz4.NewReader(r)), nil
+			},
+		},
+	}
+)
+
+// Lookup returns the algorithm of the given name.
+// No name leaves the dumps uncompressed
+func Lookup(name string) (*Algorithm, error) {
+	if name == "" || name == none.Name {
+		return none, nil
+	}
+	for _, algorithm := range algorithms {
+		if algorithm.Name == name {
+			return algorithm, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported compression %q", name)
+}
+
+// NewReader decompresses r with the algorithm detected from its first bytes.
+// A stream none of the algorithms wrote is passed through as it is
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for _, algorithm := range algorithms {
+		if bytes.HasPrefix(head, algorithm.magic) {
+			return algorithm.reader(buffered)
+		}
+	}
+	return ioutil.NopCloser(buffered), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
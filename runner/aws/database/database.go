@@ -0,0 +1,131 @@
+package database
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+)
+
+// Formats of the dumps
+const (
+	// SQL script, restored with the client of the database
+	Plain = "plain"
+
+	// pg_dump archive, restored with pg_restore
+	Custom = "custom"
+
+	// pg_dump archive of one file per table, uploaded as tar of the directory
+	Directory = "directory"
+
+	// pg_dump archive in tar format, restored with pg_restore
+	Tar = "tar"
+)
+
+// Filter selects the parts of the database that are dumped.
+// Empty includes dump everything
+type Filter struct {
+	IncludeSchemas   []string
+	ExcludeSchemas   []string
+	IncludeTables    []string
+	ExcludeTables    []string
+	ExcludeTableData []string
+}
+
+func newFilter() Filter {
+	return Filter{
+		IncludeSchemas:   utils.GetEnvList("DUMP_INCLUDE_SCHEMAS"),
+		ExcludeSchemas:   utils.GetEnvList("DUMP_EXCLUDE_SCHEMAS"),
+		IncludeTables:    utils.GetEnvList("DUMP_INCLUDE_TABLES"),
+		ExcludeTables:    utils.GetEnvList("DUMP_EXCLUDE_TABLES"),
+		ExcludeTableData: utils.GetEnvList("DUMP_EXCLUDE_TABLE_DATA"),
+	}
+}
+
+// flags turns every entry into flag=entry
+func flags(flag string, entries []string) []string {
+	var flags []string
+	for _, entry := range entries {
+		flags = append(flags, flag+"="+entry)
+	}
+	return flags
+}
+
+// Driver builds the dump and restore commands of one database engine.
+// Every driver reads its own connection settings from the environment
+type Driver interface {
+	// Name of the dumped database, used as prefix of the dump objects
+	Database() string
+
+	// Host the dump is taken from
+	Host() string
+
+	// Format of the dumps the driver writes
+	Format() string
+
+	// DumpCommand writes a dump of the database to stdout.
+	// A dump in the directory format is written into dir instead
+	DumpCommand(dir string) *exec.Cmd
+
+	// RestoreCommand loads a dump of the given format from path into the database.
+	// The path of a dump in the directory format is its directory
+	RestoreCommand(path string, format string) *exec.Cmd
+
+	// VersionCommand prints the version of the database server to stdout
+	VersionCommand() *exec.Cmd
+
+	// ListCommand prints the names of the databases on the server to stdout, one per line.
+	// Templates and system databases are not listed
+	ListCommand() *exec.Cmd
+
+	// GlobalsCommand writes the objects shared by all databases, like roles, to stdout.
+	// It is nil for engines without them
+	GlobalsCommand() *exec.Cmd
+
+	// WithDatabase returns a driver for another database on the same server
+	WithDatabase(name string) Driver
+}
+
+// Extension of the dump key for the format
+func Extension(format string) string {
+	switch format {
+	case Custom:
+		return ".dump"
+	case Directory:
+		return ".dir.tar"
+	case Tar:
+		return ".tar"
+	default:
+		return ".sql"
+	}
+}
+
+// FormatOf tells the format of a dump by its key <database>-<unix><extension>,
+// followed by the extensions of compression and encryption
+func FormatOf(key string) string {
+	extensions := strings.Split(key[strings.LastIndex(key, "-")+1:], ".")
+	for i, extension := range extensions {
+		switch {
+		case extension == "dir" && i+1 < len(extensions) && extensions[i+1] == "tar":
+			return Directory
+		case extension == "dump":
+			return Custom
+		case extension == "tar":
+			return Tar
+		}
+	}
+	return Plain
+}
+
+// New returns the driver of the given database type as named in the Dbackup spec
+func New(kind string) (Driver, error) {
+	switch kind {
+	case "postgres":
+		return newPostgres()
+	case "mysql":
+		return newMysql()
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", kind)
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+)
+
+type mysql struct {
+	host     string
+	port     string
+	database string
+	username string
+	password string
+
+	filter Filter
+}
+
+func newMysql() (*mysql, error) {
+	m := &mysql{
+		host:     utils.GetEnvVariable("MYSQL_HOST", ""),
+		port:     utils.GetEnvVariable("MYSQL_PORT", "3306"),
+		database: utils.GetEnvVariable("MYSQL_DATABASE", ""),
+		username: utils.GetEnvVariable("MYSQL_USERNAME", ""),
+		password: utils.GetEnvVariable("MYSQL_PASSWORD", ""),
+		filter:   newFilter(),
+	}
+
+	if len(m.filter.IncludeSchemas) > 0 || len(m.filter.ExcludeSchemas) > 0 {
+		return nil, fmt.Errorf("mysql has no schemas inside a database, only tables can be filtered")
+	}
+	return m, nil
+}
+
+func (m *mysql) Database() string {
+	return m.database
+}
+
+func (m *mysql) Host() string {
+	return m.host
+}
+
+// mysqldump writes SQL only
+func (m *mysql) Format() string {
+	return Plain
+}
+
+// The password is handed over in MYSQL_PWD,
+// so it does not show up in the process list
+func (m *mysql) command(name string, arguments ...string) *exec.Cmd {
+	arguments = append([]string{
+		"--host=" + m.host,
+		"--port=" + m.port,
+		"--user=" + m.username,
+	}, arguments...)
+
+	cmd := exec.Command(name, arguments...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+m.password)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// --single-transaction takes a consistent snapshot of InnoDB tables
+// without locking them for the duration of the dump.
+// --ignore-table-data is a flag of the MariaDB mysqldump of the runner image
+func (m *mysql) DumpCommand(dir string) *exec.Cmd {
+	arguments := []string{
+		"--single-transaction",
+		"--routines",
+		"--triggers",
+		"--verbose",
+	}
+	arguments = append(arguments, flags("--ignore-table", m.qualified(m.filter.ExcludeTables))...)
+	arguments = append(arguments, flags("--ignore-table-data", m.qualified(m.filter.ExcludeTableData))...)
+
+	// tables following the database are the only ones dumped
+	arguments = append(arguments, m.database)
+	arguments = append(arguments, m.filter.IncludeTables...)
+	return m.command("mysqldump", arguments...)
+}
+
+// qualified prefixes the tables with the database, mysqldump ignores tables as <database>.<table>
+func (m *mysql) qualified(tables []string) []string {
+	var qualified []string
+	for _, table := range tables {
+		qualified = append(qualified, m.database+"."+table)
+	}
+	return qualified
+}
+
+func (m *mysql) RestoreCommand(path string, format string) *exec.Cmd {
+	cmd := m.command("mysql", "--execute=source "+path, m.database)
+	cmd.Stdout = os.Stdout
+	return cmd
+}
+
+func (m *mysql) VersionCommand() *exec.Cmd {
+	return m.command("mysql", "--skip-column-names", "--batch", "--execute=SELECT VERSION()")
+}
+
+func (m *mysql) ListCommand() *exec.Cmd {
+	return m.command("mysql", "--skip-column-names", "--batch",
+		"--execute=SELECT schema_name FROM information_schema.schemata "+
+			"WHERE schema_name NOT IN ('information_schema', 'performance_schema', 'mysql', 'sys') ORDER BY schema_name")
+}
+
+// The users of mysql live in the mysql database, which is a system database
+// and not dumped with the others
+func (m *mysql) GlobalsCommand() *exec.Cmd {
+	return nil
+}
+
+func (m *mysql) WithDatabase(name string) Driver {
+	other := *m
+	other.database = name
+	return &other
+}
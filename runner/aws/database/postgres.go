@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+)
+
+type postgres struct {
+	host     string
+	port     string
+	database string
+	username string
+	password string
+
+	format string
+	jobs   int
+	filter Filter
+
+	// the runner compresses the dump stream
+	compressed bool
+}
+
+func newPostgres() (*postgres, error) {
+	p := &postgres{
+		host:       utils.GetEnvVariable("POSTGRES_HOST", ""),
+		port:       utils.GetEnvVariable("POSTGRES_PORT", "5432"),
+		database:   utils.GetEnvVariable("POSTGRES_DATABASE", ""),
+		username:   utils.GetEnvVariable("POSTGRES_USERNAME", ""),
+		password:   utils.GetEnvVariable("POSTGRES_PASSWORD", ""),
+		format:     utils.GetEnvVariable("POSTGRES_FORMAT", Plain),
+		jobs:       utils.GetEnvInt("POSTGRES_JOBS", 1),
+		filter:     newFilter(),
+		compressed: utils.GetEnvVariable("COMPRESSION", "none") != "none",
+	}
+
+	switch p.format {
+	case Plain, Custom, Directory, Tar:
+	default:
+		return nil, fmt.Errorf("unsupported postgres dump format %q", p.format)
+	}
+	if p.jobs < 1 {
+		return nil, fmt.Errorf("postgres jobs has to be at least 1, not %d", p.jobs)
+	}
+	return p, nil
+}
+
+func (p *postgres) Database() string {
+	return p.database
+}
+
+func (p *postgres) Host() string {
+	return p.host
+}
+
+func (p *postgres) Format() string {
+	return p.format
+}
+
+// The password is handed over in PGPASSWORD,
+// so it does not show up in the process list.
+// pg_dumpall takes a connection string in --dbname, its database to connect to is --database
+func (p *postgres) command(name string, arguments ...string) *exec.Cmd {
+	database := "--dbname=" + p.database
+	if name == "pg_dumpall" {
+		database = "--database=" + p.database
+	}
+	arguments = append([]string{
+		"--host=" + p.host,
+		"--port=" + p.port,
+		"--username=" + p.username,
+		database,
+	}, arguments...)
+
+	cmd := exec.Command(name, arguments...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+p.password)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// Only the directory format is dumped in parallel.
+// The archive formats are compressed by pg_dump unless the runner compresses them
+func (p *postgres) DumpCommand(dir string) *exec.Cmd {
+	arguments := []string{"--no-owner", "--verbose", "--format=" + p.format}
+	if p.compressed && (p.format == Custom || p.format == Directory) {
+		arguments = append(arguments, "--compress=0")
+	}
+	if p.format == Directory {
+		arguments = append(arguments, "--file="+dir, "--jobs="+strconv.Itoa(p.jobs))
+	}
+
+	arguments = append(arguments, flags("--schema", p.filter.IncludeSchemas)...)
+	arguments = append(arguments, flags("--exclude-schema", p.filter.ExcludeSchemas)...)
+	arguments = append(arguments, flags("--table", p.filter.IncludeTables)...)
+	arguments = append(arguments, flags("--exclude-table", p.filter.ExcludeTables)...)
+	arguments = append(arguments, flags("--exclude-table-data", p.filter.ExcludeTableData)...)
+	return p.command("pg_dump", arguments...)
+}
+
+// pg_restore restores the custom and directory formats in parallel, not tar
+func (p *postgres) RestoreCommand(path string, format string) *exec.Cmd {
+	if format == Plain {
+		cmd := p.command("psql", "--set=ON_ERROR_STOP=1", "--file="+path)
+		cmd.Stdout = os.Stdout
+		return cmd
+	}
+
+	arguments := []string{"--no-owner", "--exit-on-error", "--verbose", "--format=" + format}
+	if format != Tar {
+		arguments = append(arguments, "--jobs="+strconv.Itoa(p.jobs))
+	}
+	cmd := p.command("pg_restore", append(arguments, path)...)
+	cmd.Stdout = os.Stdout
+	return cmd
+}
+
+func (p *postgres) VersionCommand() *exec.Cmd {
+	return p.command("psql", "--tuples-only", "--no-align", "--command=SHOW server_version")
+}
+
+func (p *postgres) ListCommand() *exec.Cmd {
+	return p.command("psql", "--tuples-only", "--no-align",
+		"--command=SELECT datname FROM pg_database WHERE NOT datistemplate AND datallowconn ORDER BY datname")
+}
+
+// Roles and tablespaces, pg_dump leaves them out of the dump of a database
+func (p *postgres) GlobalsCommand() *exec.Cmd {
+	return p.command("pg_dumpall", "--globals-only", "--verbose")
+}
+
+func (p *postgres) WithDatabase(name string) Driver {
+	other := *p
+	other.database = name
+	return &other
+}
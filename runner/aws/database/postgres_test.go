@@ -0,0 +1,66 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostgresCommands(t *testing.T) {
+	p := &postgres{
+		host:     "db",
+		port:     "5432",
+		database: "app",
+		username: "backup",
+		password: "secret",
+		format:   Plain,
+		jobs:     1,
+	}
+	connection := []string{"--host=db", "--port=5432", "--username=backup"}
+
+	tests := []struct {
+		name      string
+		arguments []string
+		want      []string
+	}{
+		{
+			name:      "pg_dump connects to the database by name",
+			arguments: p.DumpCommand("").Args,
+			want:      append(append([]string{"pg_dump"}, connection...), "--dbname=app", "--no-owner", "--verbose", "--format=plain"),
+		},
+		{
+			name:      "pg_dumpall connects to the database with --database",
+			arguments: p.GlobalsCommand().Args,
+			want:      append(append([]string{"pg_dumpall"}, connection...), "--database=app", "--globals-only", "--verbose"),
+		},
+		{
+			name:      "psql lists the databases of the server",
+			arguments: p.ListCommand().Args[:5],
+			want:      append(append([]string{"psql"}, connection...), "--dbname=app"),
+		},
+		{
+			name:      "dumps of another database of the server",
+			arguments: p.WithDatabase("shop").DumpCommand("").Args[:5],
+			want:      append(append([]string{"pg_dump"}, connection...), "--dbname=shop"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.arguments, test.want) {
+				t.Errorf("arguments %q, want %q", test.arguments, test.want)
+			}
+		})
+	}
+}
+
+func TestPostgresPassword(t *testing.T) {
+	cmd := (&postgres{database: "app", password: "secret"}).GlobalsCommand()
+	for _, argument := range cmd.Args {
+		if argument == "secret" || argument == "--password=secret" {
+			t.Errorf("password is passed as argument %q", argument)
+		}
+	}
+	if env := cmd.Env[len(cmd.Env)-1]; env != "PGPASSWORD=secret" {
+		t.Errorf("last env is %q, want PGPASSWORD", env)
+	}
+}
@@ -0,0 +1,277 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+	An encrypted dump is an envelope:
+
+		magic | key id length | key id | nonce | wrapped data key
+		chunks of: last flag | ciphertext length | ciphertext
+
+	Every dump has its own random data key, sealed with AES-256-GCM under the
+	key encryption key of the Secret. The dump is sealed in chunks with the data key.
+	The nonce of a chunk is its index and last flag, so chunks can not be reordered,
+	dropped or cut off at the end. The header is authenticated with every chunk
+*/
+
+// Algorithm of the envelope as stored in the object metadata
+const Algorithm = "aes-256-gcm"
+
+const (
+	keySize   = 32
+	chunkSize = 64 * 1024
+)
+
+var magic = []byte("DBKPENC1")
+
+// Key is the key encryption key the data keys of the dumps are wrapped with
+type Key struct {
+	// ID names the key in the metadata of the dumps, so a restore can tell which key it needs
+	ID string
+
+	aead cipher.AEAD
+}
+
+// ParseKey reads a base64 encoded 256 bit key.
+// Without an id the key is named by its fingerprint
+func ParseKey(encoded, id string) (*Key, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64 encoded: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key has %d bytes, AES-256 needs %d", len(key), keySize)
+	}
+
+	if id == "" {
+		fingerprint := sha256.Sum256(key)
+		id = "sha256:" + hex.EncodeToString(fingerprint[:8])
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key id is longer than 255 bytes")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: id, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewWriter encrypts everything written to it into w.
+// Closing it seals the last chunk, w is not closed
+func (k *Key) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	authenticated := append(append(append([]byte{}, magic...), byte(len(k.ID))), k.ID...)
+	header := append(append([]byte{}, authenticated...), nonce...)
+	header = k.aead.Seal(header, nonce, dataKey, authenticated)
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: header}, nil
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buffer []byte
+	index  uint64
+}
+
+// A full chunk is only sealed once more data follows,
+// the last chunk has to be sealed as the last one
+func (w *writer) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for len(w.buffer) > chunkSize {
+		if err := w.seal(w.buffer[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buffer = w.buffer[chunkSize:]
+	}
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	return w.seal(w.buffer, true)
+}
+
+// The header goes out with the first chunk,
+// so nothing is written to w before the first write
+func (w *writer) seal(chunk []byte, last bool) error {
+	var record []byte
+	if w.index == 0 {
+		record = append(record, w.header...)
+	}
+
+	start := len(record)
+	record = append(record, 0, 0, 0, 0, 0)
+	if last {
+		record[start] = 1
+	}
+	record = w.aead.Seal(record, chunkNonce(w.index, last), chunk, w.header)
+	binary.BigEndian.PutUint32(record[start+1:start+5], uint32(len(record)-start-5))
+	w.index++
+
+	_, err := w.w.Write(record)
+	return err
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Encrypted tells whether the stream starts with an envelope
+func Encrypted(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(head, magic), nil
+}
+
+// KeyID reads the id of the key the envelope of the stream is sealed with
+func KeyID(r *bufio.Reader) (string, error) {
+	head, err := r.Peek(len(magic) + 1)
+	if err != nil {
+		return "", err
+	}
+	id, err := r.Peek(len(head) + int(head[len(magic)]))
+	if err != nil {
+		return "", err
+	}
+	return string(id[len(head):]), nil
+}
+
+// ErrWrongKey is returned for an envelope sealed with another key
+var ErrWrongKey = errors.New("dump was encrypted with another key")
+
+// NewReader decrypts the envelope read from r
+func (k *Key) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, errors.New("dump is not encrypted")
+	}
+
+	rest := make([]byte, int(header[len(magic)])+k.aead.NonceSize()+keySize+k.aead.Overhead())
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	header = append(header, rest...)
+
+	idEnd := len(magic) + 1 + int(header[len(magic)])
+	if id := string(header[len(magic)+1 : idEnd]); id != k.ID {
+		return nil, fmt.Errorf("%w %q, not with %q", ErrWrongKey, id, k.ID)
+	}
+
+	nonceEnd := idEnd + k.aead.NonceSize()
+	dataKey, err := k.aead.Open(nil, header[idEnd:nonceEnd], header[nonceEnd:], header[:idEnd])
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key: %v", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: r, aead: aead, header: header}, nil
+}
+
+type reader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunk  []byte
+	index  uint64
+	done   bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *reader) open() error {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r.r, prefix); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	last := prefix[0] == 1
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > uint32(chunkSize+r.aead.Overhead()) {
+		return errors.New("encrypted chunk is too large")
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return err
+	}
+
+	chunk, err := r.aead.Open(sealed[:0], chunkNonce(r.index, last), sealed, r.header)
+	if err != nil {
+		return fmt.Errorf("encrypted chunk %d does not authenticate: %v", r.index, err)
+	}
+	r.index++
+	r.chunk = chunk
+
+	if last {
+		r.done = true
+		var extra [1]byte
+		if n, _ := r.r.Read(extra[:]); n > 0 {
+			return errors.New("data after the last encrypted chunk")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func testKey(t *testing.T, fill byte, id string) *Key {
+	t.Helper()
+	key, err := ParseKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, keySize)), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func seal(t *testing.T, key *Key, plain []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w, err := key.NewWriter(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func open(key *Key, sealed []byte) ([]byte, error) {
+	r, err := key.NewReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// records splits an envelope into its header and chunk records
+func records(t *testing.T, key *Key, sealed []byte) ([]byte, [][]byte) {
+	t.Helper()
+	headerSize := len(magic) + 1 + len(key.ID) + key.aead.NonceSize() + keySize + key.aead.Overhead()
+	header, rest := sealed[:headerSize], sealed[headerSize:]
+
+	var chunks [][]byte
+	for len(rest) > 0 {
+		size := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	return header, chunks
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t, 1, "")
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i * 7)
+		}
+
+		sealed := seal(t, key, plain)
+		_, chunks := records(t, key, sealed)
+
+		// the last chunk is sealed on close, an empty dump has an empty one
+		want := (size + chunkSize - 1) / chunkSize
+		if want == 0 {
+			want = 1
+		}
+		if len(chunks) != want {
+			t.Errorf("%d bytes are sealed in %d chunks, want %d", size, len(chunks), want)
+		}
+
+		opened, err := open(key, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(opened, plain) {
+			t.Errorf("%d bytes do not round trip", size)
+		}
+	}
+}
+
+func TestEncryptedAndKeyID(t *testing.T) {
+	key := testKey(t, 1, "backup-key")
+	sealed := bufio.NewReader(bytes.NewReader(seal(t, key, []byte("select 1;"))))
+
+	if encrypted, err := Encrypted(sealed); err != nil || !encrypted {
+		t.Errorf("envelope is not detected: %t, %v", encrypted, err)
+	}
+	if id, err := KeyID(sealed); err != nil || id != key.ID {
+		t.Errorf("key id is %q, %v, want %q", id, err, key.ID)
+	}
+	if encrypted, err := Encrypted(bufio.NewReader(bytes.NewReader([]byte("select 1;")))); err != nil || encrypted {
+		t.Errorf("plain dump is detected as encrypted: %t, %v", encrypted, err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	key := testKey(t, 1, "")
+	sealed := seal(t, key, bytes.Repeat([]byte("x"), 2*chunkSize+10))
+	header, chunks := records(t, key, sealed)
+
+	join := func(chunks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, chunks...), nil)
+	}
+	last := chunks[len(chunks)-1]
+
+	tests := []struct {
+		name   string
+		sealed []byte
+	}{
+		{"truncated last chunk", sealed[:len(sealed)-1]},
+		{"missing last chunk", join(chunks[0], chunks[1])},
+		{"header only", header},
+		{"reordered chunks", join(chunks[1], chunks[0], last)},
+		{"trailing data", append(append([]byte{}, sealed...), 0)},
+		{"flipped bit", func() []byte {
+			flipped := append([]byte{}, sealed...)
+			flipped[len(header)+10] ^= 1
+			return flipped
+		}()},
+		{"last flag moved", func() []byte {
+			moved := join(chunks[0], chunks[1], last)
+			moved[len(header)+len(chunks[0])] = 1
+			return moved[:len(header)+len(chunks[0])+len(chunks[1])]
+		}()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := open(key, test.sealed); err == nil {
+				t.Error("tampered envelope opens")
+			}
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealed := seal(t, testKey(t, 1, ""), []byte("select 1;"))
+
+	if _, err := open(testKey(t, 2, ""), sealed); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("another key gives %v, want ErrWrongKey", err)
+	}
+
+	// a key named like the right one can not unwrap the data key
+	if _, err := open(testKey(t, 2, testKey(t, 1, "").ID), sealed); err == nil || errors.Is(err, ErrWrongKey) {
+		t.Errorf("another key of the same id gives %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("not base64", ""); err == nil {
+		t.Error("key that is not base64 is accepted")
+	}
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString(make([]byte, 16)), ""); err == nil {
+		t.Error("128 bit key is accepted")
+	}
+	if a, b := testKey(t, 1, ""), testKey(t, 1, ""); a.ID != b.ID {
+		t.Errorf("fingerprints of the same key differ: %s, %s", a.ID, b.ID)
+	}
+}
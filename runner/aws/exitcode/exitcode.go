@@ -0,0 +1,81 @@
+package exitcode
+
+import (
+	"strings"
+)
+
+// Exit codes of the runner. The operator retries a failed run by them
+const (
+	// The run failed for a reason the runner does not know, it is not retried
+	Failed = 1
+
+	// The run failed for a reason that may be gone on the next try,
+	// like an unreachable host, a lock timeout or a throttled object store
+	Retryable = 75
+
+	// The database or the object store rejected the credentials
+	AuthFailed = 77
+
+	// The runner is configured wrong
+	Config = 78
+)
+
+// Messages of the database clients and the object stores by the exit code they map to
+var (
+	authFailures = []string{
+		"password authentication failed",
+		"no pg_hba.conf entry",
+		"access denied for user",
+		"permission denied",
+		"invalidaccesskeyid",
+		"signaturedoesnotmatch",
+		"accessdenied",
+		"authenticationfailed",
+		"authorizationfailure",
+		"invalid_grant",
+	}
+
+	retryableFailures = []string{
+		"could not connect",
+		"can't connect to mysql server",
+		"connection refused",
+		"connection reset",
+		"lost connection",
+		"server closed the connection unexpectedly",
+		"too many connections",
+		"too many clients",
+		"the database system is starting up",
+		"the database system is shutting down",
+		"lock timeout",
+		"lock wait timeout exceeded",
+		"deadlock",
+		"timeout",
+		"timed out",
+		"no such host",
+		"could not translate host name",
+		"unknown mysql server host",
+		"temporary failure in name resolution",
+		"slowdown",
+		"throttl",
+		"serviceunavailable",
+		"serverbusy",
+		"internalerror",
+	}
+)
+
+// Classify maps the error of a failed run to the exit code of the runner
+func Classify(err error) int {
+	message := strings.ToLower(err.Error())
+
+	for _, failure := range authFailures {
+		if strings.Contains(message, failure) {
+			return AuthFailed
+		}
+	}
+	for _, failure := range retryableFailures {
+		if strings.Contains(message, failure) {
+			return Retryable
+		}
+	}
+	return Failed
+}
@@ -0,0 +1,63 @@
+package exitcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		message string
+		code    int
+	}{
+		// pg_dump, psql and pg_restore
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: FATAL:  password authentication failed for user "backup"`, AuthFailed},
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: FATAL:  no pg_hba.conf entry for host "10.1.2.3", user "backup", database "app", SSL off`, AuthFailed},
+		{`pg_dump: exit status 1: pg_dump: error: query failed: ERROR:  permission denied for table invoices`, AuthFailed},
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: Connection refused
+	Is the server running on that host and accepting TCP/IP connections?`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: could not translate host name "db" to address: Name or service not known`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: could not translate host name "db" to address: Temporary failure in name resolution`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: FATAL:  the database system is starting up`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: FATAL:  sorry, too many clients already`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: query failed: ERROR:  canceling statement due to lock timeout`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: Dumping the contents of table "events" failed: PQgetResult() failed.
+pg_dump: error: Error message from server: server closed the connection unexpectedly`, Retryable},
+		{`pg_dump: exit status 1: pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: FATAL:  database "app" does not exist`, Failed},
+		{`pg_restore: exit status 1: pg_restore: error: could not execute query: ERROR:  relation "users" already exists`, Failed},
+
+		// mysqldump and mysql
+		{`mysqldump: exit status 2: mysqldump: Got error: 1045: Access denied for user 'backup'@'10.1.2.3' (using password: YES) when trying to connect`, AuthFailed},
+		{`mysqldump: exit status 2: mysqldump: Got error: 2002: Can't connect to MySQL server on 'db' (115) when trying to connect`, Retryable},
+		{`mysqldump: exit status 2: mysqldump: Got error: 2005: Unknown MySQL server host 'db' (-2) when trying to connect`, Retryable},
+		{`mysqldump: exit status 2: mysqldump: Got error: 1040: Too many connections when trying to connect`, Retryable},
+		{`mysqldump: exit status 2: mysqldump: Error 2013: Lost connection to MySQL server during query when dumping table 'events' at row: 1204`, Retryable},
+		{`mysqldump: exit status 2: mysqldump: Couldn't execute 'FLUSH TABLES WITH READ LOCK': Lock wait timeout exceeded; try restarting transaction (1205)`, Retryable},
+		{`mysqldump: exit status 2: mysqldump: Got error: 1049: Unknown database 'app' when selecting the database`, Failed},
+
+		// S3
+		{"InvalidAccessKeyId: The AWS Access Key Id you provided does not exist in our records.\n\tstatus code: 403, request id: 4442587FB7D0A2F9", AuthFailed},
+		{"SignatureDoesNotMatch: The request signature we calculated does not match the signature you provided. Check your key and signing method.\n\tstatus code: 403", AuthFailed},
+		{"AccessDenied: Access Denied\n\tstatus code: 403, request id: 4442587FB7D0A2F9", AuthFailed},
+		{"SlowDown: Please reduce your request rate.\n\tstatus code: 503, request id: 4442587FB7D0A2F9", Retryable},
+		{"InternalError: We encountered an internal error. Please try again.\n\tstatus code: 500", Retryable},
+		{"RequestError: send request failed\ncaused by: Put \"https://backups.s3.eu-central-1.amazonaws.com/app-1622512800.sql\": dial tcp: lookup backups.s3.eu-central-1.amazonaws.com: no such host", Retryable},
+		{"RequestError: send request failed\ncaused by: Put \"http://minio:9000/backups/app-1622512800.sql\": read tcp 10.0.0.1:51234->10.0.0.2:9000: read: connection reset by peer", Retryable},
+		{"NoSuchBucket: The specified bucket does not exist\n\tstatus code: 404, request id: 4442587FB7D0A2F9", Failed},
+
+		// Azure Blob and GCS
+		{"===== RESPONSE ERROR (ServiceCode=AuthenticationFailed) =====\nDescription=Server failed to authenticate the request.", AuthFailed},
+		{"===== RESPONSE ERROR (ServiceCode=ServerBusy) =====\nDescription=The server is busy.", Retryable},
+		{"Post \"https://oauth2.googleapis.com/token\": oauth2: cannot fetch token: 400 Bad Request\nResponse: {\"error\":\"invalid_grant\",\"error_description\":\"Invalid JWT Signature.\"}", AuthFailed},
+		{"googleapi: Error 503: Backend Error, backendError; context deadline exceeded (Client.Timeout exceeded while awaiting headers)", Retryable},
+
+		// the runner itself
+		{"unexpected EOF", Failed},
+	}
+
+	for _, test := range tests {
+		if code := Classify(errors.New(test.message)); code != test.code {
+			t.Errorf("%q exits with %d, want %d", test.message, code, test.code)
+		}
+	}
+}
@@ -3,6 +3,12 @@ module github.com/ahmedmahmo/discovery-operator/runner/aws
 go 1.16
 
 require (
+	cloud.google.com/go/storage v1.18.2
+	github.com/Azure/azure-pipeline-go v0.2.3
+	github.com/Azure/azure-storage-blob-go v0.14.0
 	github.com/aws/aws-sdk-go v1.42.22
-	github.com/habx/pg-commands v0.2.0 // indirect
+	github.com/klauspost/compress v1.13.6
+	github.com/pierrec/lz4/v4 v4.1.12
+	google.golang.org/api v0.58.0
+	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -17,113 +17,772 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
-	"strings"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	archive "github.com/ahmedmahmo/discovery-operator/runner/aws/archive"
+	compression "github.com/ahmedmahmo/discovery-operator/runner/aws/compression"
+	database "github.com/ahmedmahmo/discovery-operator/runner/aws/database"
+	encryption "github.com/ahmedmahmo/discovery-operator/runner/aws/encryption"
+	exitcode "github.com/ahmedmahmo/discovery-operator/runner/aws/exitcode"
+	manifest "github.com/ahmedmahmo/discovery-operator/runner/aws/manifest"
+	retention "github.com/ahmedmahmo/discovery-operator/runner/aws/retention"
+	storage "github.com/ahmedmahmo/discovery-operator/runner/aws/storage"
 	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 var (
-	// AWS Variables
-	AWS_S3_REGION         = utils.GetEnvVariable("AWS_S3_REGION", "")
-	AWS_S3_BUCKET 	      = utils.GetEnvVariable("AWS_S3_BUCKET", "")
-	AWS_ACCESS_KEY_ID     = utils.GetEnvVariable("AWS_ACCESS_KEY_ID", "")
-	AWS_SECRET_ACCESS_KEY = utils.GetEnvVariable("AWS_SECRET_ACCESS_KEY", "")
-
-	// Postgres variables
-	POSTGRES_HOST 		  = utils.GetEnvVariable("POSTGRES_HOST", "")
-	POSTGRES_PORT		  = utils.GetEnvVariable("POSTGRES_PORT", "")
-	POSTGRES_DATABASE     = utils.GetEnvVariable("POSTGRES_DATABASE", "")
-	POSTGRES_USERNAME     = utils.GetEnvVariable("POSTGRES_USERNAME", "")
-	POSTGRES_PASSWORD     = utils.GetEnvVariable("POSTGRES_PASSWORD", "")			
-)
+	// Runner variables
+	RUNNER_MODE        = utils.GetEnvVariable("RUNNER_MODE", "backup")
+	RESTORE_OBJECT_KEY = utils.GetEnvVariable("RESTORE_OBJECT_KEY", "")
+	DATABASE_TYPE      = utils.GetEnvVariable("DATABASE_TYPE", "postgres")
+	CLOUD_PROVIDER     = utils.GetEnvVariable("CLOUD_PROVIDER", "aws")
+	STORAGE_PREFIX     = utils.GetEnvVariable("STORAGE_PREFIX", "")
+	RESULT_FILE        = utils.GetEnvVariable("RESULT_FILE", "/dev/termination-log")
+	DBACKUP_UID        = utils.GetEnvVariable("DBACKUP_UID", "")
+	EXPECTED_CHECKSUM  = utils.GetEnvVariable("EXPECTED_CHECKSUM", "")
+
+	// All databases variables
+	ALL_DATABASES         = utils.GetEnvVariable("ALL_DATABASES", "false") == "true"
+	ALL_DATABASES_INCLUDE = utils.GetEnvList("ALL_DATABASES_INCLUDE")
+	ALL_DATABASES_EXCLUDE = utils.GetEnvList("ALL_DATABASES_EXCLUDE")
+
+	// Compression variables
+	COMPRESSION       = utils.GetEnvVariable("COMPRESSION", "none")
+	COMPRESSION_LEVEL = utils.GetEnvInt("COMPRESSION_LEVEL", 0)
 
-const (
-	command = "pg_dump"
+	// Encryption variables
+	ENCRYPTION_KEY    = utils.GetEnvVariable("ENCRYPTION_KEY", "")
+	ENCRYPTION_KEY_ID = utils.GetEnvVariable("ENCRYPTION_KEY_ID", "")
+
+	// Retention variables
+	RETENTION = retention.Policy{
+		KeepLast:    utils.GetEnvInt("RETENTION_KEEP_LAST", 0),
+		KeepDaily:   utils.GetEnvInt("RETENTION_KEEP_DAILY", 0),
+		KeepWeekly:  utils.GetEnvInt("RETENTION_KEEP_WEEKLY", 0),
+		KeepMonthly: utils.GetEnvInt("RETENTION_KEEP_MONTHLY", 0),
+		KeepYearly:  utils.GetEnvInt("RETENTION_KEEP_YEARLY", 0),
+	}
 )
 
-func main()  {
+func main() {
 	fmt.Println("Runner is up...")
-	fmt.Printf("Starting dump from %s\n", POSTGRES_HOST)
 
-	fmt.Println("Envs: \n", POSTGRES_PORT, POSTGRES_DATABASE, POSTGRES_USERNAME, POSTGRES_PASSWORD)
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, CLOUD_PROVIDER)
+	if err != nil {
+		exit(exitcode.Config, err)
+	}
+
+	driver, err := database.New(DATABASE_TYPE)
+	if err != nil {
+		exit(exitcode.Config, err)
+	}
+
+	switch RUNNER_MODE {
+	case "backup":
+		algorithm, err := compression.Lookup(COMPRESSION)
+		if err != nil {
+			exit(exitcode.Config, err)
+		}
+		if ALL_DATABASES {
+			backupAll(ctx, driver, store, algorithm, encryptionKey())
+		} else {
+			backup(ctx, driver, store, algorithm, encryptionKey())
+		}
+	case "restore":
+		restore(ctx, driver, store, encryptionKey())
+	case "prune":
+		if ALL_DATABASES {
+			if err := pruneRuns(ctx, store); err != nil {
+				fail(err)
+			}
+		} else if err := prune(ctx, driver, store); err != nil {
+			fail(err)
+		}
+	default:
+		exit(exitcode.Config, fmt.Errorf("unknown runner mode %q", RUNNER_MODE))
+	}
+}
+
+// encryptionKey is the key of the dumps, nil when they are not encrypted
+func encryptionKey() *encryption.Key {
+	if ENCRYPTION_KEY == "" {
+		return nil
+	}
+	key, err := encryption.ParseKey(ENCRYPTION_KEY, ENCRYPTION_KEY_ID)
+	if err != nil {
+		exit(exitcode.Config, err)
+	}
+	return key
+}
+
+// fail ends a failed run with the exit code of its error
+func fail(err error) {
+	exit(exitcode.Classify(err), err)
+}
+
+// exit ends the runner with the code the operator retries by.
+// The error goes to the termination message of the container
+func exit(code int, err error) {
+	fmt.Println(err)
+	if err := ioutil.WriteFile(RESULT_FILE, []byte(err.Error()), 0644); err != nil {
+		fmt.Printf("unable to write error to %s: %v\n", RESULT_FILE, err)
+	}
+	os.Exit(code)
+}
+
+// tailWriter keeps the last bytes written to it
+type tailWriter struct {
+	tail []byte
+	max  int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if len(w.tail) > w.max {
+		w.tail = w.tail[len(w.tail)-w.max:]
+	}
+	return len(p), nil
+}
+
+// captureStderr passes the stderr of a database client through and keeps its tail.
+// The client's message is what tells a retryable failure from a fatal one
+func captureStderr(cmd *exec.Cmd) *tailWriter {
+	stderr := &tailWriter{max: 512}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	return stderr
+}
+
+// clientError adds the tail of the stderr of a failed database client to its error
+func clientError(cmd *exec.Cmd, err error, stderr *tailWriter) error {
+	return fmt.Errorf("%s: %v: %s", path.Base(cmd.Path), err, strings.TrimSpace(string(stderr.tail)))
+}
 
+// run runs a database client to its end
+func run(cmd *exec.Cmd) error {
+	stderr := captureStderr(cmd)
+	if err := cmd.Run(); err != nil {
+		return clientError(cmd, err, stderr)
+	}
+	return nil
+}
+
+// Result of a backup as read by the operator from the termination message.
+// The JSON names match the BackupResult of the Dbackup status
+type Result struct {
+	Key             string `json:"key"`
+	Location        string `json:"location"`
+	Size            int64  `json:"size"`
+	Checksum        string `json:"checksum"`
+	Duration        string `json:"duration"`
+	DatabaseVersion string `json:"databaseVersion,omitempty"`
+}
+
+func backup(ctx context.Context, driver database.Driver, store storage.Storage, algorithm *compression.Algorithm, key *encryption.Key) {
+	fmt.Printf("Starting dump from %s\n", driver.Host())
+
+	started := time.Now()
 	f := strings.Join([]string{
-		POSTGRES_DATABASE,
+		driver.Database(),
 		"-",
 		strconv.FormatInt(
-		time.Now().Unix(), 10),
-		".sql",
-	},"")
-
-	arguments := []string{}
-	arguments = append(arguments, "--no-owner")
-	arguments = append(arguments, "--verbose")
-	arguments = append(arguments, strings.Join([]string{
-		"--dbname=postgresql://",
-		POSTGRES_USERNAME,
-		":",
-		POSTGRES_PASSWORD,
-		"@",
-		POSTGRES_HOST,
-		":",
-		POSTGRES_PORT,
-		"/",
-		POSTGRES_DATABASE,
-	}, ""))
-
-	arguments = append(arguments, "-f")
-	arguments = append(arguments, f)
-
-
-	cmd := exec.Command(command, arguments...)
-	err := cmd.Run()
-	
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	}else {
-		fmt.Printf("Dumped successfully to %s", f)
-	}
-
-	
-	fmt.Println("Starting uploading dump ")
-	fmt.Println("Envs: \n", AWS_S3_REGION, AWS_S3_BUCKET, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)
-	s3Configration := &aws.Config{
-		Region: aws.String(AWS_S3_REGION),
-		Credentials: credentials.NewStaticCredentials(
-			AWS_ACCESS_KEY_ID,
-			AWS_SECRET_ACCESS_KEY,
-			"",
-		),
-	}
-
-	s3Session := session.New(s3Configration)
-	
-	uploadManger := s3manager.NewUploader(s3Session)
-	
-	opened, err := os.Open(f)
-	if err != nil {
-		panic(err)
-	}
-
-	result, err := uploadManger.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(AWS_S3_BUCKET),
-		Key:    aws.String(f),
-		Body:   opened,
+			started.Unix(), 10),
+	}, "")
+	objectKey := path.Join(STORAGE_PREFIX, f+dumpExtension(driver.Format(), algorithm, key))
+
+	fmt.Printf("Streaming %s dump, %s compressed, to %s, encrypted: %t\n", driver.Format(), algorithm.Name, CLOUD_PROVIDER, key != nil)
+
+	dumpManifest, location, err := stream(ctx, store, objectKey, algorithm, key, func(w io.Writer) error {
+		return dump(driver, w)
 	})
 	if err != nil {
-		panic(err)
+		fail(err)
+	}
+
+	fmt.Printf("file uploaded to, %s\n", location)
+
+	version := databaseVersion(driver)
+
+	/*
+		The manifest is uploaded once the dump is complete,
+		a dump without manifest was never finished
+	*/
+	dumpManifest.Database = driver.Database()
+	dumpManifest.DatabaseType = DATABASE_TYPE
+	dumpManifest.DatabaseVersion = version
+	dumpManifest.Format = driver.Format()
+	dumpManifest.Compression = algorithm.Name
+	dumpManifest.DbackupUID = DBACKUP_UID
+	dumpManifest.Created = started.UTC()
+	if key != nil {
+		dumpManifest.EncryptionKeyID = key.ID
+	}
+	if err := dumpManifest.Upload(ctx, store); err != nil {
+		fail(err)
+	}
+
+	writeResult(Result{
+		Key:             objectKey,
+		Location:        location,
+		Size:            dumpManifest.Size,
+		Checksum:        dumpManifest.Checksum,
+		Duration:        time.Since(started).Round(time.Second).String(),
+		DatabaseVersion: version,
+	})
+
+	/*
+		the dump is uploaded and reported, a failed prune is only logged
+		and left to the next run, failing here would count the backup as failed
+	*/
+	if err := prune(ctx, driver, store); err != nil {
+		fmt.Printf("unable to prune expired dumps: %v\n", err)
+	}
+}
+
+// dumpExtension is the extension of the dump format,
+// followed by the extensions of compression and encryption
+func dumpExtension(format string, algorithm *compression.Algorithm, key *encryption.Key) string {
+	extension := database.Extension(format) + algorithm.Extension
+	if key != nil {
+		extension += ".enc"
+	}
+	return extension
+}
+
+// stream uploads what write writes to objectKey, compressed and encrypted on the way.
+// The returned manifest holds key, checksum and size of the object
+func stream(ctx context.Context, store storage.Storage, objectKey string, algorithm *compression.Algorithm, key *encryption.Key, write func(w io.Writer) error) (*manifest.Manifest, string, error) {
+	/*
+		The dump is piped straight into the upload.
+		A dump that fails closes the pipe with its error, so the upload
+		is aborted instead of finalizing a truncated object
+	*/
+	reader, writer := io.Pipe()
+
+	/*
+		The dump is compressed before it is encrypted, encrypted data does not compress.
+		An encrypted dump has no Content-Encoding, the store could not decode it.
+		The metadata names the key instead
+	*/
+	metadata := storage.Metadata{ContentEncoding: algorithm.ContentEncoding}
+	var encrypter io.WriteCloser
+	var compressed io.Writer = writer
+	if key != nil {
+		var err error
+		encrypter, err = key.NewWriter(writer)
+		if err != nil {
+			return nil, "", err
+		}
+		compressed = encrypter
+		metadata = storage.Metadata{Properties: map[string]string{
+			"compression":       algorithm.Name,
+			"encryption":        encryption.Algorithm,
+			"encryption_key_id": key.ID,
+		}}
+	}
+
+	compressor, err := algorithm.NewWriter(compressed, COMPRESSION_LEVEL)
+	if err != nil {
+		exit(exitcode.Config, err)
+	}
+
+	dumped := make(chan error, 1)
+	go func() {
+		err := write(compressor)
+		if err == nil {
+			// flushes the end of the compressed stream and seals the last chunk
+			err = compressor.Close()
+			if err == nil && encrypter != nil {
+				err = encrypter.Close()
+			}
+		}
+		writer.CloseWithError(err)
+		dumped <- err
+	}()
+
+	/*
+		The checksum and size are taken from the bytes that are uploaded
+	*/
+	hash := sha256.New()
+	counter := &countingWriter{}
+
+	location, err := store.Upload(ctx, objectKey, io.TeeReader(reader, io.MultiWriter(hash, counter)), metadata)
+	if err != nil {
+		// stop the dump in case the upload gave up before it
+		reader.CloseWithError(err)
+		if dumpErr := <-dumped; dumpErr != nil {
+			return nil, "", dumpErr
+		}
+		return nil, "", err
+	}
+	if err := <-dumped; err != nil {
+		return nil, "", err
+	}
+
+	return &manifest.Manifest{
+		Key:      objectKey,
+		Checksum: "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:     counter.n,
+	}, location, nil
+}
+
+// backupAll dumps every database of the server the filters select into one run prefix.
+// The roles and other globals go next to the dumps, one manifest describes the run.
+// A failed run deletes what it uploaded, a run without manifest never finished
+func backupAll(ctx context.Context, driver database.Driver, store storage.Storage, algorithm *compression.Algorithm, key *encryption.Key) {
+	fmt.Printf("Starting dump of all databases from %s\n", driver.Host())
+
+	started := time.Now()
+	runManifest := &manifest.Run{
+		Prefix:          path.Join(STORAGE_PREFIX, allDatabasesPrefix+strconv.FormatInt(started.Unix(), 10)),
+		DatabaseType:    DATABASE_TYPE,
+		DatabaseVersion: databaseVersion(driver),
+		Compression:     algorithm.Name,
+		DbackupUID:      DBACKUP_UID,
+		Created:         started.UTC(),
+	}
+	if key != nil {
+		runManifest.EncryptionKeyID = key.ID
+	}
+
+	var uploaded []string
+	abort := func(err error) {
+		for _, objectKey := range uploaded {
+			if deleteErr := store.Delete(ctx, objectKey); deleteErr != nil {
+				fmt.Printf("unable to delete %s of the failed run: %v\n", objectKey, deleteErr)
+			}
+		}
+		fail(err)
+	}
+
+	names, err := listDatabases(driver)
+	if err != nil {
+		fail(err)
+	}
+	if len(names) == 0 {
+		exit(exitcode.Config, fmt.Errorf("no database on %s matches the filters", driver.Host()))
+	}
+
+	if globals := driver.GlobalsCommand(); globals != nil {
+		objectKey := path.Join(runManifest.Prefix, "globals"+dumpExtension(database.Plain, algorithm, key))
+		runManifest.Globals, _, err = stream(ctx, store, objectKey, algorithm, key, func(w io.Writer) error {
+			globals.Stdout = w
+			return run(globals)
+		})
+		if err != nil {
+			abort(err)
+		}
+		runManifest.Globals.Format = database.Plain
+		uploaded = append(uploaded, objectKey)
+		fmt.Printf("globals uploaded to %s\n", objectKey)
+	}
+
+	var size int64
+	for _, name := range names {
+		target := driver.WithDatabase(name)
+		objectKey := path.Join(runManifest.Prefix, name+dumpExtension(target.Format(), algorithm, key))
+
+		dumpManifest, _, err := stream(ctx, store, objectKey, algorithm, key, func(w io.Writer) error {
+			return dump(target, w)
+		})
+		if err != nil {
+			abort(fmt.Errorf("dump of database %s: %w", name, err))
+		}
+		uploaded = append(uploaded, objectKey)
+
+		dumpManifest.Database = name
+		dumpManifest.DatabaseType = DATABASE_TYPE
+		dumpManifest.Format = target.Format()
+		dumpManifest.Compression = algorithm.Name
+		runManifest.Dumps = append(runManifest.Dumps, *dumpManifest)
+		size += dumpManifest.Size
+		fmt.Printf("database %s uploaded to %s\n", name, objectKey)
+	}
+
+	location, encoded, err := runManifest.Upload(ctx, store)
+	if err != nil {
+		abort(err)
+	}
+	checksum := sha256.Sum256(encoded)
+
+	/*
+		The result points to the manifest of the run, its checksum is the one of the manifest
+	*/
+	writeResult(Result{
+		Key:             manifest.RunKey(runManifest.Prefix),
+		Location:        location,
+		Size:            size,
+		Checksum:        "sha256:" + hex.EncodeToString(checksum[:]),
+		Duration:        time.Since(started).Round(time.Second).String(),
+		DatabaseVersion: runManifest.DatabaseVersion,
+	})
+
+	if err := pruneRuns(ctx, store); err != nil {
+		fmt.Printf("unable to prune expired runs: %v\n", err)
+	}
+}
+
+// listDatabases lists the databases on the server and keeps the ones the filters select.
+// Filters are shell patterns like app_*
+func listDatabases(driver database.Driver) ([]string, error) {
+	cmd := driver.ListCommand()
+	stderr := captureStderr(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, clientError(cmd, err, stderr)
+	}
+
+	matches := func(patterns []string, name string) (bool, error) {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid database pattern %q: %v", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		included := len(ALL_DATABASES_INCLUDE) == 0
+		if !included {
+			if included, err = matches(ALL_DATABASES_INCLUDE, name); err != nil {
+				return nil, err
+			}
+		}
+		excluded, err := matches(ALL_DATABASES_EXCLUDE, name)
+		if err != nil {
+			return nil, err
+		}
+		if included && !excluded {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// dump writes the dump of the database to w.
+// The directory format can not be written to a stream, it is dumped
+// to the disk of the pod and sent as tar archive of the directory
+func dump(driver database.Driver, w io.Writer) error {
+	if driver.Format() != database.Directory {
+		cmd := driver.DumpCommand("")
+		cmd.Stdout = w
+		return run(cmd)
+	}
+
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// pg_dump creates the directory of the dump itself
+	dumpDir := path.Join(dir, driver.Database())
+	if err := run(driver.DumpCommand(dumpDir)); err != nil {
+		return err
+	}
+	return archive.Write(dumpDir, w)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// databaseVersion asks the server for its version.
+// The version is informational, a failing query does not fail the backup
+func databaseVersion(driver database.Driver) string {
+	out, err := driver.VersionCommand().Output()
+	if err != nil {
+		fmt.Printf("unable to query database version: %v\n", err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeResult writes the result as JSON to the termination message file of the container
+func writeResult(result Result) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		fail(err)
+	}
+	if err := ioutil.WriteFile(RESULT_FILE, encoded, 0644); err != nil {
+		fmt.Printf("unable to write result to %s: %v\n", RESULT_FILE, err)
+	}
+}
+
+// prune deletes the dumps of the database the retention policy does not keep.
+// It runs after every successful upload. A manifest goes with its dump
+func prune(ctx context.Context, driver database.Driver, store storage.Storage) error {
+	if RETENTION.Empty() {
+		return nil
+	}
+
+	objects, err := store.List(ctx, path.Join(STORAGE_PREFIX, driver.Database()+"-"))
+	if err != nil {
+		return err
 	}
+	objects = dumpsOf(objects, driver.Database())
+
+	manifests := make(map[string]bool)
+	for _, object := range objects {
+		if manifest.IsManifest(object.Key) {
+			manifests[object.Key] = true
+		}
+	}
+
+	for _, object := range RETENTION.Expired(manifest.Dumps(objects)) {
+		if err := store.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+		if manifests[manifest.Key(object.Key)] {
+			if err := store.Delete(ctx, manifest.Key(object.Key)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("pruned %s\n", object.Key)
+	}
+	return nil
+}
+
+// dumpsOf keeps the objects named <database>-<unix time>.<extension> and their manifests.
+// Listing by prefix also finds the dumps of databases whose name only
+// starts with the one of the database, like app-staging for app
+func dumpsOf(objects []storage.Object, name string) []storage.Object {
+	own := regexp.MustCompile("^" + regexp.QuoteMeta(path.Join(STORAGE_PREFIX, name)) + `-\d+\.`)
+
+	var dumps []storage.Object
+	for _, object := range objects {
+		if own.MatchString(object.Key) {
+			dumps = append(dumps, object)
+		}
+	}
+	return dumps
+}
+
+// runs of all databases are stored under <prefix>/all-<unix>/
+const allDatabasesPrefix = "all-"
+
+// pruneRuns deletes the runs of all databases the retention policy does not keep.
+// A run is as old as its manifest, everything under its prefix goes with it.
+// Runs without manifest are left alone, they may still be uploading
+func pruneRuns(ctx context.Context, store storage.Storage) error {
+	if RETENTION.Empty() {
+		return nil
+	}
+
+	objects, err := store.List(ctx, path.Join(STORAGE_PREFIX, allDatabasesPrefix))
+	if err != nil {
+		return err
+	}
+
+	runKey := regexp.MustCompile("^" + regexp.QuoteMeta(path.Join(STORAGE_PREFIX, allDatabasesPrefix)) + `\d+/` + regexp.QuoteMeta(manifest.RunName) + "$")
+
+	var runs []storage.Object
+	for _, object := range objects {
+		if runKey.MatchString(object.Key) {
+			runs = append(runs, object)
+		}
+	}
+
+	for _, expired := range RETENTION.Expired(runs) {
+		prefix := path.Dir(expired.Key) + "/"
+		for _, object := range objects {
+			// the manifest is deleted last, a half pruned run is still found by the next prune
+			if strings.HasPrefix(object.Key, prefix) && object.Key != expired.Key {
+				if err := store.Delete(ctx, object.Key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := store.Delete(ctx, expired.Key); err != nil {
+			return err
+		}
+		fmt.Printf("pruned run %s\n", prefix)
+	}
+	return nil
+}
+
+// latestObjectKey finds the most recent dump of the database in the bucket.
+// Dumps are named <prefix>/<database>-<unix>.sql by the backup,
+// followed by the extension of the compression
+func latestObjectKey(ctx context.Context, driver database.Driver, store storage.Storage) (string, error) {
+	objects, err := store.List(ctx, path.Join(STORAGE_PREFIX, driver.Database()+"-"))
+	if err != nil {
+		return "", err
+	}
+
+	objects = manifest.Dumps(dumpsOf(objects, driver.Database()))
 
-	fmt.Printf("file uploaded to, %s\n", result.Location)
-}
\ No newline at end of file
+	var latest *storage.Object
+	for i, object := range objects {
+		if latest == nil || object.LastModified.After(latest.LastModified) {
+			latest = &objects[i]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no dump of database %q found", driver.Database())
+	}
+	return latest.Key, nil
+}
+
+func restore(ctx context.Context, driver database.Driver, store storage.Storage, key *encryption.Key) {
+	objectKey := RESTORE_OBJECT_KEY
+	if objectKey == "" {
+		latest, err := latestObjectKey(ctx, driver, store)
+		if err != nil {
+			fail(err)
+		}
+		objectKey = latest
+	}
+	if manifest.IsManifest(objectKey) {
+		exit(exitcode.Config, fmt.Errorf("%s is a manifest, restore one of the dumps it lists", objectKey))
+	}
+
+	dumpManifest, err := manifest.Get(ctx, store, objectKey)
+	if err != nil {
+		fail(err)
+	}
+	if dumpManifest == nil {
+		fmt.Printf("no manifest found for %s, it is restored unverified\n", objectKey)
+	}
+
+	fmt.Printf("Starting download of %s\n", objectKey)
+
+	/*
+		The manifest knows the format of the dump, older dumps are told by their key
+	*/
+	format := database.FormatOf(objectKey)
+	if dumpManifest != nil && dumpManifest.Format != "" {
+		format = dumpManifest.Format
+	}
+
+	/*
+		The download is decrypted and decompressed on its way to disk.
+		Both are detected from the dump itself, its key or
+		metadata may have been changed since it was uploaded
+	*/
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(store.Download(ctx, objectKey, writer))
+	}()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	downloaded := io.TeeReader(reader, io.MultiWriter(hash, counter))
+
+	plain, err := decrypt(bufio.NewReader(downloaded), key)
+	if err != nil {
+		fail(err)
+	}
+
+	decompressed, err := compression.NewReader(plain)
+	if err != nil {
+		fail(err)
+	}
+	restorePath := "restore" + database.Extension(format)
+	if format == database.Directory {
+		restorePath = "restore"
+		err = archive.Extract(decompressed, restorePath)
+	} else {
+		err = writeFile(restorePath, decompressed)
+	}
+	if err != nil {
+		fail(err)
+	}
+	if err := decompressed.Close(); err != nil {
+		fail(err)
+	}
+
+	/*
+		The dump is verified before the database is touched.
+		Whatever follows the end of the compressed stream is read as well,
+		so the checksum covers the whole object
+	*/
+	if _, err := io.Copy(ioutil.Discard, downloaded); err != nil {
+		fail(err)
+	}
+	checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	if dumpManifest != nil {
+		if err := dumpManifest.Verify(checksum, counter.n); err != nil {
+			fail(err)
+		}
+	}
+	if EXPECTED_CHECKSUM != "" && EXPECTED_CHECKSUM != checksum {
+		fail(fmt.Errorf("checksum mismatch of %s: expected %s, downloaded dump has %s", objectKey, EXPECTED_CHECKSUM, checksum))
+	}
+	fmt.Printf("verified %s, %s\n", objectKey, checksum)
+
+	fmt.Printf("Starting restore to %s\n", driver.Host())
+
+	cmd := driver.RestoreCommand(restorePath, format)
+	if err := run(cmd); err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("restored %s successfully\n", objectKey)
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// decrypt passes an unencrypted dump through as it is.
+// A missing or wrong key is a configuration error, retrying does not help
+func decrypt(r *bufio.Reader, key *encryption.Key) (io.Reader, error) {
+	encrypted, err := encryption.Encrypted(r)
+	if err != nil || !encrypted {
+		return r, err
+	}
+
+	if key == nil {
+		id, err := encryption.KeyID(r)
+		if err != nil {
+			return nil, err
+		}
+		exit(exitcode.Config, fmt.Errorf("dump is encrypted with key %q, no encryption key is configured", id))
+	}
+
+	plain, err := key.NewReader(r)
+	if errors.Is(err, encryption.ErrWrongKey) {
+		exit(exitcode.Config, err)
+	}
+	return plain, err
+}
@@ -0,0 +1,154 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	storage "github.com/ahmedmahmo/discovery-operator/runner/aws/storage"
+)
+
+// Suffix of the manifest key, appended to the key of its dump
+const Suffix = ".manifest.json"
+
+// Manifest describes a dump, it is stored next to it at <dump key>.manifest.json
+type Manifest struct {
+	Key             string    `json:"key"`
+	Checksum        string    `json:"checksum"`
+	Size            int64     `json:"size"`
+	Database        string    `json:"database"`
+	DatabaseType    string    `json:"databaseType"`
+	DatabaseVersion string    `json:"databaseVersion,omitempty"`
+	Format          string    `json:"format"`
+	Compression     string    `json:"compression"`
+	EncryptionKeyID string    `json:"encryptionKeyId,omitempty"`
+	DbackupUID      string    `json:"dbackupUid,omitempty"`
+	Created         time.Time `json:"created"`
+}
+
+// Run describes the dumps of all databases of a server, taken in one run.
+// It is stored at <run prefix>/run.manifest.json, next to the dumps of the run
+type Run struct {
+	Prefix          string     `json:"prefix"`
+	DatabaseType    string     `json:"databaseType"`
+	DatabaseVersion string     `json:"databaseVersion,omitempty"`
+	Compression     string     `json:"compression"`
+	EncryptionKeyID string     `json:"encryptionKeyId,omitempty"`
+	DbackupUID      string     `json:"dbackupUid,omitempty"`
+	Created         time.Time  `json:"created"`
+	Globals         *Manifest  `json:"globals,omitempty"`
+	Dumps           []Manifest `json:"dumps"`
+}
+
+// RunName is the name of the manifest of a run inside the run prefix
+const RunName = "run" + Suffix
+
+// RunKey of the manifest of the run stored under prefix
+func RunKey(prefix string) string {
+	return path.Join(prefix, RunName)
+}
+
+// Upload stores the manifest of the run and returns its location and content
+func (r *Run) Upload(ctx context.Context, store storage.Storage) (string, []byte, error) {
+	encoded, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	location, err := store.Upload(ctx, RunKey(r.Prefix), bytes.NewReader(encoded), storage.Metadata{})
+	return location, encoded, err
+}
+
+// Key of the manifest of the dump stored at key
+func Key(key string) string {
+	return key + Suffix
+}
+
+// IsManifest tells manifests apart from dumps when listing the bucket
+func IsManifest(key string) bool {
+	return strings.HasSuffix(key, Suffix)
+}
+
+// Dumps drops the manifests from the listed objects
+func Dumps(objects []storage.Object) []storage.Object {
+	var dumps []storage.Object
+	for _, object := range objects {
+		if !IsManifest(object.Key) {
+			dumps = append(dumps, object)
+		}
+	}
+	return dumps
+}
+
+// Upload stores the manifest next to its dump
+func (m *Manifest) Upload(ctx context.Context, store storage.Storage) error {
+	encoded, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = store.Upload(ctx, Key(m.Key), bytes.NewReader(encoded), storage.Metadata{})
+	return err
+}
+
+// Get reads the manifest of the dump stored at key.
+// A dump of a run is described by the manifest of its run.
+// Dumps uploaded before manifests existed have none, they give nil
+func Get(ctx context.Context, store storage.Storage, key string) (*Manifest, error) {
+	var m Manifest
+	found, err := read(ctx, store, Key(key), &m)
+	if err != nil || found {
+		return &m, err
+	}
+
+	var run Run
+	found, err = read(ctx, store, RunKey(path.Dir(key)), &run)
+	if err != nil || !found {
+		return nil, err
+	}
+	for i := range run.Dumps {
+		if run.Dumps[i].Key == key {
+			return &run.Dumps[i], nil
+		}
+	}
+	if run.Globals != nil && run.Globals.Key == key {
+		return run.Globals, nil
+	}
+	return nil, nil
+}
+
+// read decodes the manifest stored at key, found is false when there is none
+func read(ctx context.Context, store storage.Storage, key string, manifest interface{}) (found bool, err error) {
+	objects, err := store.List(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	for _, object := range objects {
+		found = found || object.Key == key
+	}
+	if !found {
+		return false, nil
+	}
+
+	var encoded bytes.Buffer
+	if err := store.Download(ctx, key, &encoded); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(encoded.Bytes(), manifest); err != nil {
+		return false, fmt.Errorf("unable to read manifest %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// Verify compares the checksum and size of the downloaded dump with the manifest
+func (m *Manifest) Verify(checksum string, size int64) error {
+	if m.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch of %s: manifest has %s, downloaded dump has %s", m.Key, m.Checksum, checksum)
+	}
+	if m.Size != size {
+		return fmt.Errorf("size mismatch of %s: manifest has %d bytes, downloaded dump has %d", m.Key, m.Size, size)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	storage "github.com/ahmedmahmo/discovery-operator/runner/aws/storage"
+)
+
+// Policy decides which dumps are kept in the bucket.
+// The rules add up, a dump is kept as long as one rule keeps it
+type Policy struct {
+	// most recent dumps
+	KeepLast int
+
+	// newest dump of each of the most recent days, weeks, months and years
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+}
+
+// Empty policies keep everything
+func (p Policy) Empty() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 && p.KeepMonthly == 0 && p.KeepYearly == 0
+}
+
+// period of a dump for the grandfather-father-son rules
+type period func(t time.Time) string
+
+var (
+	daily period = func(t time.Time) string {
+		return t.Format("2006-01-02")
+	}
+	weekly period = func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	}
+	monthly period = func(t time.Time) string {
+		return t.Format("2006-01")
+	}
+	yearly period = func(t time.Time) string {
+		return t.Format("2006")
+	}
+)
+
+// Expired returns the objects none of the rules keeps, oldest first
+func (p Policy) Expired(objects []storage.Object) []storage.Object {
+	if p.Empty() {
+		return nil
+	}
+
+	sorted := append([]storage.Object{}, objects...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.After(sorted[j].LastModified)
+	})
+
+	keep := make(map[string]bool)
+	for i := 0; i < p.KeepLast && i < len(sorted); i++ {
+		keep[sorted[i].Key] = true
+	}
+
+	/*
+		walking from the newest dump, the first dump seen in a period
+		is the newest of it and is kept until enough periods are covered
+	*/
+	rule := func(limit int, of period) {
+		seen := make(map[string]bool)
+		for _, object := range sorted {
+			if len(seen) >= limit {
+				return
+			}
+			name := of(object.LastModified.UTC())
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			keep[object.Key] = true
+		}
+	}
+	rule(p.KeepDaily, daily)
+	rule(p.KeepWeekly, weekly)
+	rule(p.KeepMonthly, monthly)
+	rule(p.KeepYearly, yearly)
+
+	var expired []storage.Object
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !keep[sorted[i].Key] {
+			expired = append(expired, sorted[i])
+		}
+	}
+	return expired
+}
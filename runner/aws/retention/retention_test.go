@@ -0,0 +1,110 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	storage "github.com/ahmedmahmo/discovery-operator/runner/aws/storage"
+)
+
+func TestExpired(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	at := func(key string, t time.Time) storage.Object {
+		return storage.Object{Key: key, LastModified: t}
+	}
+	hourly := func(day int, hours ...int) []storage.Object {
+		var objects []storage.Object
+		for _, hour := range hours {
+			t := time.Date(2021, 6, day, hour, 0, 0, 0, time.UTC)
+			objects = append(objects, at(t.Format("02T15"), t))
+		}
+		return objects
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		objects []storage.Object
+		expired []string
+	}{
+		{
+			name:    "empty policy keeps everything",
+			policy:  Policy{},
+			objects: hourly(1, 1, 2, 3),
+		},
+		{
+			name:    "keep last drops the oldest, oldest first",
+			policy:  Policy{KeepLast: 2},
+			objects: hourly(1, 3, 1, 4, 2, 5),
+			expired: []string{"01T01", "01T02", "01T03"},
+		},
+		{
+			name:    "keep last above the number of dumps",
+			policy:  Policy{KeepLast: 10},
+			objects: hourly(1, 1, 2),
+		},
+		{
+			name:    "keep last and daily overlap on the newest dump",
+			policy:  Policy{KeepLast: 2, KeepDaily: 2},
+			objects: append(append(hourly(1, 10, 20), hourly(2, 10, 20)...), hourly(3, 10, 20)...),
+			expired: []string{"01T10", "01T20", "02T10"},
+		},
+		{
+			name:    "daily keeps the newest dump of a day",
+			policy:  Policy{KeepDaily: 3},
+			objects: append(append(hourly(1, 10, 20), hourly(2, 10, 20)...), hourly(3, 10, 20)...),
+			expired: []string{"01T10", "02T10", "03T10"},
+		},
+		{
+			name:   "weekly uses ISO weeks across the year boundary",
+			policy: Policy{KeepWeekly: 2},
+			objects: []storage.Object{
+				at("2020-12-28", time.Date(2020, 12, 28, 12, 0, 0, 0, time.UTC)),
+				at("2020-12-31", time.Date(2020, 12, 31, 12, 0, 0, 0, time.UTC)),
+				at("2021-01-03", time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC)),
+				at("2021-01-04", time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC)),
+				at("2020-12-20", time.Date(2020, 12, 20, 12, 0, 0, 0, time.UTC)),
+			},
+			expired: []string{"2020-12-20", "2020-12-28", "2020-12-31"},
+		},
+		{
+			name:   "periods are UTC, not the zone of the timestamps",
+			policy: Policy{KeepDaily: 2},
+			objects: []storage.Object{
+				at("x", time.Date(2021, 6, 1, 23, 30, 0, 0, berlin)),
+				at("y", time.Date(2021, 6, 2, 1, 30, 0, 0, berlin)),
+				at("z", time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC)),
+			},
+			expired: []string{"x"},
+		},
+		{
+			name:   "monthly and yearly add up",
+			policy: Policy{KeepMonthly: 2, KeepYearly: 2},
+			objects: []storage.Object{
+				at("2019-12", time.Date(2019, 12, 15, 0, 0, 0, 0, time.UTC)),
+				at("2020-11", time.Date(2020, 11, 15, 0, 0, 0, 0, time.UTC)),
+				at("2020-12", time.Date(2020, 12, 15, 0, 0, 0, 0, time.UTC)),
+				at("2021-01-a", time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)),
+				at("2021-01-b", time.Date(2021, 1, 20, 0, 0, 0, 0, time.UTC)),
+			},
+			expired: []string{"2019-12", "2020-11", "2021-01-a"},
+		},
+		{
+			name:   "no dumps",
+			policy: Policy{KeepLast: 1, KeepDaily: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var expired []string
+			for _, object := range test.policy.Expired(test.objects) {
+				expired = append(expired, object.Key)
+			}
+			if !reflect.DeepEqual(expired, test.expired) {
+				t.Errorf("expired %v, want %v", expired, test.expired)
+			}
+		})
+	}
+}
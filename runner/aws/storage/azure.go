@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	azureBufferSize = 4 * 1024 * 1024
+	azureMaxBuffers = 4
+)
+
+type azureStorage struct {
+	container azblob.ContainerURL
+}
+
+// The endpoint defaults to the public blob service of the account.
+// Setting it to http://<host>:10000/devstoreaccount1 targets Azurite
+func newAzure() (*azureStorage, error) {
+	account := utils.GetEnvVariable("AZURE_STORAGE_ACCOUNT", "")
+	endpoint := utils.GetEnvVariable("STORAGE_ENDPOINT",
+		utils.GetEnvVariable("AZURE_STORAGE_ENDPOINT", fmt.Sprintf("https://%s.blob.core.windows.net", account)))
+	container := utils.GetEnvVariable("STORAGE_BUCKET", utils.GetEnvVariable("AZURE_STORAGE_CONTAINER", ""))
+
+	credential, err := azblob.NewSharedKeyCredential(account, utils.GetEnvVariable("AZURE_STORAGE_KEY", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	containerURL, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + container)
+	if err != nil {
+		return nil, err
+	}
+
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{HTTPSender: rawSender()})
+	return &azureStorage{
+		container: azblob.NewContainerURL(*containerURL, p),
+	}, nil
+}
+
+// rawSender sends the requests of the pipeline with a transport that does not decompress,
+// the default one hands out a gzip encoded dump decompressed
+func rawSender() pipeline.Factory {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	client := &http.Client{Transport: transport}
+
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			response, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(response), err
+		}
+	})
+}
+
+func (a *azureStorage) Upload(ctx context.Context, key string, body io.Reader, metadata Metadata) (string, error) {
+	blob := a.container.NewBlockBlobURL(key)
+
+	_, err := azblob.UploadStreamToBlockBlob(ctx, body, blob, azblob.UploadStreamToBlockBlobOptions{
+		BufferSize: azureBufferSize,
+		MaxBuffers: azureMaxBuffers,
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			ContentEncoding: metadata.ContentEncoding,
+		},
+		Metadata: metadata.Properties,
+	})
+	if err != nil {
+		return "", err
+	}
+	location := blob.URL()
+	return location.String(), nil
+}
+
+func (a *azureStorage) Download(ctx context.Context, key string, w io.Writer) error {
+	response, err := a.container.NewBlobURL(key).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return err
+	}
+
+	body := response.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (a *azureStorage) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		page, err := a.container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		marker = page.NextMarker
+
+		for _, blob := range page.Segment.BlobItems {
+			object := Object{
+				Key:          blob.Name,
+				LastModified: blob.Properties.LastModified,
+			}
+			if blob.Properties.ContentLength != nil {
+				object.Size = *blob.Properties.ContentLength
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+func (a *azureStorage) Delete(ctx context.Context, key string) error {
+	_, err := a.container.NewBlobURL(key).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type gcsStorage struct {
+	bucket *gcs.BucketHandle
+	name   string
+}
+
+// Credentials are the service account key mounted from the credentials Secret,
+// otherwise the client reads them from GOOGLE_APPLICATION_CREDENTIALS.
+// STORAGE_EMULATOR_HOST or an endpoint points it to fake-gcs-server instead of Google
+func newGCS(ctx context.Context) (*gcsStorage, error) {
+	var options []option.ClientOption
+	if credentials := utils.GetEnvVariable("GOOGLE_APPLICATION_CREDENTIALS_JSON", ""); credentials != "" {
+		options = append(options, option.WithCredentialsJSON([]byte(credentials)))
+	}
+	if endpoint := utils.GetEnvVariable("STORAGE_ENDPOINT", ""); endpoint != "" {
+		options = append(options, option.WithEndpoint(endpoint))
+	}
+
+	client, err := gcs.NewClient(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	name := utils.GetEnvVariable("STORAGE_BUCKET", utils.GetEnvVariable("GCS_BUCKET", ""))
+	return &gcsStorage{
+		bucket: client.Bucket(name),
+		name:   name,
+	}, nil
+}
+
+// Closing the writer commits the object, a failed upload
+// cancels the context instead so no partial object is left behind
+func (g *gcsStorage) Upload(ctx context.Context, key string, body io.Reader, metadata Metadata) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.bucket.Object(key).NewWriter(ctx)
+	w.ContentEncoding = metadata.ContentEncoding
+	w.Metadata = metadata.Properties
+	if _, err := io.Copy(w, body); err != nil {
+		cancel()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gs://%s/%s", g.name, key), nil
+}
+
+// Without ReadCompressed a gzip encoded dump is decompressed by the client
+func (g *gcsStorage) Download(ctx context.Context, key string, w io.Writer) error {
+	r, err := g.bucket.Object(key).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (g *gcsStorage) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	it := g.bucket.Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, Object{
+			Key:          attrs.Name,
+			Size:         attrs.Size,
+			LastModified: attrs.Updated,
+		})
+	}
+	return objects, nil
+}
+
+func (g *gcsStorage) Delete(ctx context.Context, key string) error {
+	return g.bucket.Object(key).Delete(ctx)
+}
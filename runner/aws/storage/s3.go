@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	utils "github.com/ahmedmahmo/discovery-operator/runner/aws/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// A streamed upload has no known size, so the part size bounds the object
+// at 10000 parts. The uploader buffers concurrency parts in memory
+const (
+	s3PartSize    = 64 * 1024 * 1024
+	s3Concurrency = 2
+)
+
+type s3Storage struct {
+	bucket  string
+	client  *s3.S3
+	session *session.Session
+}
+
+// An endpoint together with path style addressing points the
+// backend to an S3 compatible store like MinIO
+func newS3() (*s3Storage, error) {
+	s3Configration := &aws.Config{
+		Region: aws.String(utils.GetEnvVariable("STORAGE_REGION", utils.GetEnvVariable("AWS_S3_REGION", ""))),
+		Credentials: credentials.NewStaticCredentials(
+			utils.GetEnvVariable("AWS_ACCESS_KEY_ID", ""),
+			utils.GetEnvVariable("AWS_SECRET_ACCESS_KEY", ""),
+			"",
+		),
+	}
+	if endpoint := utils.GetEnvVariable("STORAGE_ENDPOINT", utils.GetEnvVariable("AWS_S3_ENDPOINT", "")); endpoint != "" {
+		s3Configration.Endpoint = aws.String(endpoint)
+		s3Configration.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	s3Session, err := session.NewSession(s3Configration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Storage{
+		bucket:  utils.GetEnvVariable("STORAGE_BUCKET", utils.GetEnvVariable("AWS_S3_BUCKET", "")),
+		client:  s3.New(s3Session),
+		session: s3Session,
+	}, nil
+}
+
+func (s *s3Storage) Upload(ctx context.Context, key string, body io.Reader, metadata Metadata) (string, error) {
+	uploadManger := s3manager.NewUploader(s.session, func(u *s3manager.Uploader) {
+		u.PartSize = s3PartSize
+		u.Concurrency = s3Concurrency
+	})
+
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if metadata.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(metadata.ContentEncoding)
+	}
+	if len(metadata.Properties) > 0 {
+		input.Metadata = aws.StringMap(metadata.Properties)
+	}
+
+	result, err := uploadManger.UploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return result.Location, nil
+}
+
+// Asking for the identity encoding keeps the HTTP transport
+// from decompressing a gzip encoded dump on its own
+func (s *s3Storage) Download(ctx context.Context, key string, w io.Writer) error {
+	object, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, func(r *request.Request) {
+		r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	_, err = io.Copy(w, object.Body)
+	return err
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Object is a dump stored in the bucket
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Metadata is stored along with an uploaded object
+type Metadata struct {
+	// Content-Encoding of the object, e.g. gzip for a compressed dump
+	ContentEncoding string
+
+	// User defined metadata of the object.
+	// Names are lower case letters and underscores, which all stores accept
+	Properties map[string]string
+}
+
+// Storage is the object store the dumps are uploaded to.
+// Every backend reads its own settings from the environment
+type Storage interface {
+	// Upload streams body to key and returns the location of the stored object
+	Upload(ctx context.Context, key string, body io.Reader, metadata Metadata) (string, error)
+
+	// Download streams the object stored at key into w.
+	// The bytes are the stored ones, a Content-Encoding is never decoded on the way
+	Download(ctx context.Context, key string, w io.Writer) error
+
+	// List returns all objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	// Delete removes the object stored at key
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the storage backend of the given provider as named in the Dbackup spec
+func New(ctx context.Context, provider string) (Storage, error) {
+	switch provider {
+	case "aws":
+		return newS3()
+	case "azure":
+		return newAzure()
+	case "gcp":
+		return newGCS(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported cloud provider %q", provider)
+	}
+}
@@ -2,11 +2,31 @@ package utils
 
 import (
 	"os"
+	"strconv"
+	"strings"
 )
 
 func GetEnvVariable(key, fallback string) string {
-    if value, ok := os.LookupEnv(key); ok {
-        return value
-    }
-    return fallback
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvList splits a comma separated variable, an unset variable gives nil
+func GetEnvList(key string) []string {
+	value := GetEnvVariable(key, "")
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// GetEnvInt returns the fallback when the variable is not set or not a number
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(GetEnvVariable(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
 }